@@ -2,11 +2,14 @@ package polai
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"reflect"
+	"sort"
+	"sync"
 
 	"golang.org/x/exp/maps"
 )
@@ -41,149 +44,700 @@ type Entity struct {
 }
 
 type Attribute struct {
-	Name         string
-	StringValue  *string
-	LongValue    *int64
-	BooleanValue *bool
-	RecordValue  *map[string]interface{}
-	SetValue     *[]interface{}
+	Name           string
+	StringValue    *string
+	LongValue      *int64
+	BooleanValue   *bool
+	RecordValue    *map[string]interface{}
+	SetValue       *[]interface{}
+	EntityValue    *string
+	ExtensionValue *ExtensionValue
 }
 
-// EntityStore represents the complete set of known entities within the system.
+// ExtensionValue represents an extension value, such as an ip or decimal, which is constructed by calling the
+// function Fn with the argument Arg.
+type ExtensionValue struct {
+	Fn  string
+	Arg string
+}
+
+// EntitySource provides the entities of an entity store, allowing them to be loaded from a backend of the caller's
+// choosing. The entities are retrieved once, when first needed, and the store takes ownership of the returned slice.
+type EntitySource interface {
+	GetEntities(ctx context.Context) ([]Entity, error)
+}
+
+// JSONEntitySource reads entities from a JSON list, in either the Cedar or Amazon Verified Permissions format.
+type JSONEntitySource struct {
+	r *bufio.Reader
+}
+
+// NewJSONEntitySource returns a new instance of JSONEntitySource.
+func NewJSONEntitySource(r io.Reader) *JSONEntitySource {
+	return &JSONEntitySource{r: bufio.NewReader(r)}
+}
+
+// GetEntities reads and parses the entities.
+func (s *JSONEntitySource) GetEntities(ctx context.Context) ([]Entity, error) {
+	return readEntities(s.r)
+}
+
+// EntityStore represents the complete set of known entities within the system. It is safe for concurrent use; the
+// entities are never modified in place, so slices returned remain valid after the store changes.
 type EntityStore struct {
-	r        *bufio.Reader
+	mu       sync.RWMutex
+	src      EntitySource
 	entities *[]Entity
+	index    map[string]int
+
+	// ancestorIndex holds the identifiers of the descendants of each entity, keyed by the identifier of the ancestor.
+	ancestorIndex map[string]map[string]bool
+
+	// OverwriteEntities causes AddEntity and MergeEntities to replace an existing entity with the same identifier,
+	// rather than returning an error.
+	OverwriteEntities bool
 }
 
-// NewEntityStore returns a new instance of EntityStore.
+// NewEntityStore returns a new instance of EntityStore, reading the entities from a JSON list.
 func NewEntityStore(r io.Reader) *EntityStore {
-	return &EntityStore{r: bufio.NewReader(r)}
+	return NewEntityStoreFromSource(NewJSONEntitySource(r))
+}
+
+// NewEntityStoreFromSource returns a new instance of EntityStore, retrieving the entities from src.
+func NewEntityStoreFromSource(src EntitySource) *EntityStore {
+	return &EntityStore{src: src}
 }
 
 // SetEntities overrides all entities.
 func (e *EntityStore) SetEntities(r io.Reader) {
-	e.r = bufio.NewReader(r)
+	e.SetEntitySource(NewJSONEntitySource(r))
+}
+
+// SetEntitySource overrides all entities with those retrieved from src.
+func (e *EntityStore) SetEntitySource(src EntitySource) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.src = src
 	e.entities = nil
+	e.index = nil
+	e.ancestorIndex = nil
 }
 
 // GetEntities retrieves all entities.
 func (e *EntityStore) GetEntities() ([]Entity, error) {
+	return e.loadEntities(context.Background())
+}
+
+// loadEntities retrieves all entities, retrieving them from the source on the first call.
+func (e *EntityStore) loadEntities(ctx context.Context) ([]Entity, error) {
+	e.mu.RLock()
+	entities := e.entities
+	e.mu.RUnlock()
+	if entities != nil {
+		return *entities, nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.loadEntitiesLocked(ctx)
+}
+
+// loadEntitiesLocked is like loadEntities, but must be called with the write lock held.
+func (e *EntityStore) loadEntitiesLocked(ctx context.Context) ([]Entity, error) {
 	if e.entities == nil {
-		b, err := ioutil.ReadAll(e.r)
-		if err != nil && err != io.EOF {
+		entities, err := e.src.GetEntities(ctx)
+		if err != nil {
 			return nil, err
 		}
+		e.entities = &entities
+	}
 
-		var rawEntities []rawEntity
-		if err := json.Unmarshal(b, &rawEntities); err != nil {
-			return nil, fmt.Errorf("error parsing entity store json, %s", err.Error())
-		}
+	return *e.entities, nil
+}
+
+// MergeEntities reads further entities and adds them to those already in the store. An error is returned if any
+// identifier is already in the store or repeated within the entities read, unless OverwriteEntities is set, in which
+// case later definitions win. OverwriteEntities serves as the option to allow duplicates, which is shared with
+// AddEntity. Any index of the entities is discarded.
+func (e *EntityStore) MergeEntities(r io.Reader) error {
+	mergedEntities, err := readEntities(r)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	entities, err := e.loadEntitiesLocked(context.Background())
+	if err != nil {
+		return err
+	}
 
-		var entities []Entity
-		for _, rawEntity := range rawEntities {
-			if rawEntity.EntityId != nil {
-				rawEntity.Identifier = rawEntity.EntityId
+	positions := map[string]int{}
+	for i, entity := range entities {
+		positions[entity.Identifier] = i
+	}
+	entities = append([]Entity{}, entities...)
+	for _, entity := range mergedEntities {
+		if i, ok := positions[entity.Identifier]; ok {
+			if !e.OverwriteEntities {
+				return &EntityError{Identifier: entity.Identifier, Msg: fmt.Sprintf("entity already exists: %s", entity.Identifier)}
 			}
+			entities[i] = entity
+			continue
+		}
+		positions[entity.Identifier] = len(entities)
+		entities = append(entities, entity)
+	}
 
-			if rawEntity.Uid != "" {
-				var attributes []Attribute
-				for attrName, attrVal := range rawEntity.Attrs {
-					attribute := Attribute{
-						Name: attrName,
-					}
+	e.entities = &entities
+	e.index = nil
+	e.ancestorIndex = nil
 
-					switch attrVal.(type) {
-					case int:
-						val := int64(attrVal.(int))
-						attribute.LongValue = &val
-					case int64:
-						val := attrVal.(int64)
-						attribute.LongValue = &val
-					case float64:
-						val := int64(attrVal.(float64))
-						attribute.LongValue = &val
-					case string:
-						val := attrVal.(string)
-						attribute.StringValue = &val
-					case bool:
-						val := attrVal.(bool)
-						attribute.BooleanValue = &val
-					case map[string]interface{}:
-						val := attrVal.(map[string]interface{})
+	return nil
+}
+
+// readEntities parses a JSON list of entities.
+func readEntities(r io.Reader) ([]Entity, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var rawEntities []rawEntity
+	if err := json.Unmarshal(b, &rawEntities); err != nil {
+		return nil, &EntityError{Msg: fmt.Sprintf("error parsing entity store json, %s", err.Error())}
+	}
+
+	var entities []Entity
+	for _, rawEntity := range rawEntities {
+		if rawEntity.EntityId != nil {
+			rawEntity.Identifier = rawEntity.EntityId
+		}
+
+		if rawEntity.Uid != "" {
+			var attributes []Attribute
+			for attrName, attrVal := range rawEntity.Attrs {
+				attribute := Attribute{
+					Name: attrName,
+				}
+
+				switch attrVal.(type) {
+				case int:
+					val := int64(attrVal.(int))
+					attribute.LongValue = &val
+				case int64:
+					val := attrVal.(int64)
+					attribute.LongValue = &val
+				case float64:
+					val := int64(attrVal.(float64))
+					attribute.LongValue = &val
+				case string:
+					val := attrVal.(string)
+					attribute.StringValue = &val
+				case bool:
+					val := attrVal.(bool)
+					attribute.BooleanValue = &val
+				case map[string]interface{}:
+					val := attrVal.(map[string]interface{})
+					if entityRef, ok := val["__entity"]; ok {
+						identifier, err := parseEntityReference(entityRef)
+						if err != nil {
+							return nil, err
+						}
+						attribute.EntityValue = &identifier
+					} else if extn, ok := val["__extn"]; ok {
+						extensionValue, err := parseExtensionAttribute(extn)
+						if err != nil {
+							return nil, err
+						}
+						attribute.ExtensionValue = &extensionValue
+					} else {
 						attribute.RecordValue = &val
-					case []interface{}:
-						val := attrVal.([]interface{})
-						attribute.SetValue = &val
-					default:
-						return nil, fmt.Errorf("unknown type in attribute block: %v (%s)", attrVal, reflect.TypeOf(attrVal).String())
 					}
-
-					attributes = append(attributes, attribute)
+				case []interface{}:
+					val := attrVal.([]interface{})
+					attribute.SetValue = &val
+				default:
+					return nil, &AttributeError{
+						Entity:    rawEntity.Uid,
+						Attribute: attrName,
+						Msg:       fmt.Sprintf("unknown type in attribute block: %v (%s)", attrVal, reflect.TypeOf(attrVal).String()),
+					}
 				}
 
-				entities = append(entities, Entity{
-					Identifier: rawEntity.Uid,
-					Parents:    rawEntity.LowerParents,
-					Attributes: attributes,
+				attributes = append(attributes, attribute)
+			}
+
+			entities = append(entities, Entity{
+				Identifier: rawEntity.Uid,
+				Parents:    rawEntity.LowerParents,
+				Attributes: attributes,
+			})
+		} else if rawEntity.Identifier != nil {
+			b, _ := json.Marshal(rawEntity.Identifier.EntityID)
+			entity := Entity{
+				Identifier: fmt.Sprintf("%s::%s", rawEntity.Identifier.EntityType, string(b)),
+			}
+
+			for _, parent := range rawEntity.Parents {
+				b, _ := json.Marshal(parent.EntityID)
+				entity.Parents = append(entity.Parents, fmt.Sprintf("%s::%s", parent.EntityType, string(b)))
+			}
+
+			for attrName, attrVal := range rawEntity.Attributes {
+				// TODO: validate only one field set
+				entity.Attributes = append(entity.Attributes, Attribute{
+					Name:         attrName,
+					BooleanValue: attrVal.Boolean,
+					StringValue:  attrVal.String,
+					LongValue:    attrVal.Long,
+					RecordValue:  attrVal.Record,
+					SetValue:     attrVal.Set,
 				})
-			} else if rawEntity.Identifier != nil {
-				b, _ := json.Marshal(rawEntity.Identifier.EntityID)
-				entity := Entity{
-					Identifier: fmt.Sprintf("%s::%s", rawEntity.Identifier.EntityType, string(b)),
-				}
+			}
 
-				for _, parent := range rawEntity.Parents {
-					b, _ := json.Marshal(parent.EntityID)
-					entity.Parents = append(entity.Parents, fmt.Sprintf("%s::%s", parent.EntityType, string(b)))
-				}
+			entities = append(entities, entity)
+		} else {
+			return nil, &EntityError{Msg: "no entity identifier found in entity list item"}
+		}
+	}
 
-				for attrName, attrVal := range rawEntity.Attributes {
-					// TODO: validate only one field set
-					entity.Attributes = append(entity.Attributes, Attribute{
-						Name:         attrName,
-						BooleanValue: attrVal.Boolean,
-						StringValue:  attrVal.String,
-						LongValue:    attrVal.Long,
-						RecordValue:  attrVal.Record,
-						SetValue:     attrVal.Set,
-					})
-				}
+	return entities, nil
+}
+
+// AddEntity adds a single entity to the store without re-reading the existing entities. Any index of the entities is
+// discarded, along with the ancestor index entries of the entity's ancestors.
+func (e *EntityStore) AddEntity(entity Entity) error {
+	if !isEntityIdentifier(entity.Identifier) {
+		return &EntityError{Identifier: entity.Identifier, Msg: fmt.Sprintf("invalid entity identifier: %s", entity.Identifier)}
+	}
 
-				entities = append(entities, entity)
-			} else {
-				return nil, fmt.Errorf("no entity identifier found in entity list item")
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	entities, err := e.loadEntitiesLocked(context.Background())
+	if err != nil {
+		return err
+	}
+
+	for i, existing := range entities {
+		if existing.Identifier == entity.Identifier {
+			if !e.OverwriteEntities {
+				return &EntityError{Identifier: entity.Identifier, Msg: fmt.Sprintf("entity already exists: %s", entity.Identifier)}
 			}
+			e.invalidateAncestorsLocked(entities, append(append([]string{}, existing.Parents...), entity.Parents...))
+			entities = append([]Entity{}, entities...)
+			entities[i] = entity
+			e.entities = &entities
+			e.index = nil
+			return nil
 		}
+	}
 
-		e.entities = &entities
+	e.invalidateAncestorsLocked(entities, entity.Parents)
+	// limit the capacity so that appending copies, rather than writing to an array shared with callers
+	entities = append(entities[:len(entities):len(entities)], entity)
+	e.entities = &entities
+	e.index = nil
+
+	return nil
+}
+
+// RemoveEntity removes the entity with the provided identifier from the store, returning ErrEntityNotFound if there
+// is none. Entities which list it as a parent are left unchanged. Any index of the entities is discarded, along with
+// the ancestor index entries of the entity's ancestors.
+func (e *EntityStore) RemoveEntity(identifier string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	entities, err := e.loadEntitiesLocked(context.Background())
+	if err != nil {
+		return err
 	}
 
-	return *e.entities, nil
+	for i, entity := range entities {
+		if entity.Identifier == identifier {
+			e.invalidateAncestorsLocked(entities, entity.Parents)
+			// copy, as callers may still hold the slice previously returned by GetEntities
+			entities = append(append([]Entity{}, entities[:i]...), entities[i+1:]...)
+			e.entities = &entities
+			e.index = nil
+			return nil
+		}
+	}
+
+	return ErrEntityNotFound
+}
+
+// BuildIndex sorts the entities by identifier and indexes their positions, so that subsequent lookups of individual
+// entities no longer scan every entity. Otherwise, the index is built without sorting on the first call to GetEntity.
+// The index is discarded when the entities are overridden, added to or removed from.
+func (e *EntityStore) BuildIndex() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	entities, err := e.loadEntitiesLocked(context.Background())
+	if err != nil {
+		return err
+	}
+
+	entities = append([]Entity{}, entities...)
+	sort.SliceStable(entities, func(i, j int) bool {
+		return entities[i].Identifier < entities[j].Identifier
+	})
+	e.entities = &entities
+	e.index = indexEntities(entities)
+
+	return nil
+}
+
+// indexEntities indexes the position of the first entity with each identifier.
+func indexEntities(entities []Entity) map[string]int {
+	index := make(map[string]int, len(entities))
+	for i, entity := range entities {
+		if _, ok := index[entity.Identifier]; !ok {
+			index[entity.Identifier] = i
+		}
+	}
+
+	return index
+}
+
+// GetEntity retrieves the first entity with the provided identifier, and whether it was found. The entities are
+// indexed on the first call, so that subsequent lookups do not scan every entity.
+func (e *EntityStore) GetEntity(identifier string) (Entity, bool, error) {
+	e.mu.RLock()
+	entities, index := e.entities, e.index
+	e.mu.RUnlock()
+
+	if entities == nil || index == nil {
+		e.mu.Lock()
+		loaded, err := e.loadEntitiesLocked(context.Background())
+		if err != nil {
+			e.mu.Unlock()
+			return Entity{}, false, err
+		}
+		if e.index == nil {
+			e.index = indexEntities(loaded)
+		}
+		entities, index = e.entities, e.index
+		e.mu.Unlock()
+	}
+
+	i, ok := index[identifier]
+	if !ok {
+		return Entity{}, false, nil
+	}
+
+	return (*entities)[i], true, nil
+}
+
+// parseEntityReference converts the value of an __entity attribute, such as {"type": "User", "id": "alice"}, into an
+// entity identifier.
+func parseEntityReference(entityRef interface{}) (string, error) {
+	ref, ok := entityRef.(map[string]interface{})
+	if !ok {
+		return "", &EntityError{Msg: fmt.Sprintf("invalid entity reference in attribute block: %v", entityRef)}
+	}
+	entityType, ok := ref["type"].(string)
+	if !ok || entityType == "" {
+		return "", &EntityError{Msg: fmt.Sprintf("invalid entity reference type in attribute block: %v", entityRef)}
+	}
+	entityID, ok := ref["id"].(string)
+	if !ok {
+		return "", &EntityError{Msg: fmt.Sprintf("invalid entity reference id in attribute block: %v", entityRef)}
+	}
+
+	b, _ := json.Marshal(entityID)
+	return fmt.Sprintf("%s::%s", entityType, string(b)), nil
+}
+
+// parseExtensionAttribute converts the value of an __extn attribute, such as {"fn": "ip", "arg": "10.0.0.1"}, into an
+// extension value.
+func parseExtensionAttribute(extn interface{}) (ExtensionValue, error) {
+	ref, ok := extn.(map[string]interface{})
+	if !ok {
+		return ExtensionValue{}, &EntityError{Msg: fmt.Sprintf("invalid extension value in attribute block: %v", extn)}
+	}
+	fn, ok := ref["fn"].(string)
+	if !ok || fn == "" {
+		return ExtensionValue{}, &EntityError{Msg: fmt.Sprintf("invalid extension function in attribute block: %v", extn)}
+	}
+	arg, ok := ref["arg"].(string)
+	if !ok {
+		return ExtensionValue{}, &EntityError{Msg: fmt.Sprintf("invalid extension argument in attribute block: %v", extn)}
+	}
+
+	return ExtensionValue{Fn: fn, Arg: arg}, nil
 }
 
-// GetEntityDescendents retrieves all entities that match or are descendents of those passed in.
+// GetEntityDescendents retrieves all entities that match or are descendents of those passed in. The entities passed in
+// are always included, matching the semantics of the in operator. Use GetTrueDescendants to exclude them.
 func (e *EntityStore) GetEntityDescendents(parents []string) ([]Entity, error) {
+	return e.GetEntityDescendentsWithContext(context.Background(), parents)
+}
+
+// GetEntityDescendentsWithContext is like GetEntityDescendents, but stops traversing the hierarchy and returns the
+// context error once ctx is cancelled or its deadline passes.
+func (e *EntityStore) GetEntityDescendentsWithContext(ctx context.Context, parents []string) ([]Entity, error) {
+	baseEntities, err := e.loadEntities(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	descendants, err := descendantIdentifiers(ctx, childIdentifiers(baseEntities), parents)
+	if err != nil {
+		return nil, err
+	}
+	for _, parent := range parents {
+		descendants[parent] = true
+	}
+
+	foundEntities := map[string]Entity{} // using map[string] for dedup purposes
+	for _, baseEntity := range baseEntities {
+		if descendants[baseEntity.Identifier] {
+			foundEntities[baseEntity.Identifier] = baseEntity
+		}
+	}
+
+	return maps.Values(foundEntities), nil
+}
+
+// GetTrueDescendants retrieves all entities that are descendents of those passed in. Unlike GetEntityDescendents, the
+// entities passed in are only included if they are also a descendent of another entity passed in.
+func (e *EntityStore) GetTrueDescendants(parents []string) ([]Entity, error) {
 	baseEntities, err := e.GetEntities()
 	if err != nil {
 		return nil, err
 	}
 
+	descendants, err := descendantIdentifiers(context.Background(), childIdentifiers(baseEntities), parents)
+	if err != nil {
+		return nil, err
+	}
+
 	foundEntities := map[string]Entity{} // using map[string] for dedup purposes
-	i := 0
-	for i < len(parents) {
-		parent := parents[i]
-		for _, baseEntity := range baseEntities {
-			for _, baseEntityParent := range baseEntity.Parents {
-				if baseEntityParent == parent && !contains(parents, baseEntity.Identifier) {
-					parents = append(parents, baseEntity.Identifier)
+	for _, baseEntity := range baseEntities {
+		if descendants[baseEntity.Identifier] {
+			foundEntities[baseEntity.Identifier] = baseEntity
+		}
+	}
+
+	return maps.Values(foundEntities), nil
+}
+
+// GetEntityAncestors retrieves all entities that are parents of the entity with the provided identifier, or parents of
+// those parents and so on. Parents which are not in the store are omitted, and an error is returned if the parent
+// relationships form a cycle.
+func (e *EntityStore) GetEntityAncestors(identifier string) ([]Entity, error) {
+	entity, found, err := e.GetEntity(identifier)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return []Entity{}, nil
+	}
+
+	ancestors := map[string]Entity{} // using map[string] for dedup purposes
+	visited := map[string]bool{}
+	visiting := map[string]bool{} // the entities on the current path
+	var visit func(entity Entity) error
+	visit = func(entity Entity) error {
+		visited[entity.Identifier] = true
+		visiting[entity.Identifier] = true
+		for _, parent := range entity.Parents {
+			if visiting[parent] {
+				return &EntityError{Identifier: parent, Msg: fmt.Sprintf("cycle detected in the parents of entity %s", parent)}
+			}
+			if visited[parent] {
+				continue
+			}
+			visited[parent] = true
+
+			parentEntity, found, err := e.GetEntity(parent)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			ancestors[parent] = parentEntity
+			if err := visit(parentEntity); err != nil {
+				return err
+			}
+		}
+		visiting[entity.Identifier] = false
+
+		return nil
+	}
+	if err := visit(entity); err != nil {
+		return nil, err
+	}
+
+	return maps.Values(ancestors), nil
+}
+
+// childIdentifiers returns the identifiers of the base entities listing each parent.
+func childIdentifiers(baseEntities []Entity) map[string][]string {
+	children := map[string][]string{}
+	for _, baseEntity := range baseEntities {
+		for _, baseEntityParent := range baseEntity.Parents {
+			children[baseEntityParent] = append(children[baseEntityParent], baseEntity.Identifier)
+		}
+	}
+
+	return children
+}
+
+// BuildAncestorIndex computes the descendants of every entity listed as a parent, so that subsequent in checks do not
+// traverse the hierarchy. Otherwise, the descendants of each ancestor are computed on first use. An error is returned
+// if the parent relationships form a cycle.
+func (e *EntityStore) BuildAncestorIndex() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	entities, err := e.loadEntitiesLocked(context.Background())
+	if err != nil {
+		return err
+	}
+
+	children := childIdentifiers(entities)
+	for parent := range children {
+		if _, ok := e.ancestorIndex[parent]; ok {
+			continue
+		}
+		if _, err := e.indexAncestorLocked(context.Background(), children, parent); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// IsAncestor returns whether the entity with the identifier descendant is below the entity with the identifier
+// ancestor, i.e. whether it lists ancestor as a parent, or a parent of its parents and so on. An entity is not its own
+// ancestor, and false is returned if the entities cannot be retrieved or the parent relationships form a cycle.
+func (e *EntityStore) IsAncestor(ancestor, descendant string) bool {
+	found, err := e.hasAncestor(context.Background(), descendant, []string{ancestor})
+	return err == nil && found
+}
+
+// hasAncestor returns whether the entity with the provided identifier is below any of the ancestors, using the ancestor
+// index and computing any missing entries.
+func (e *EntityStore) hasAncestor(ctx context.Context, identifier string, ancestors []string) (bool, error) {
+	for _, ancestor := range ancestors {
+		e.mu.RLock()
+		descendants, ok := e.ancestorIndex[ancestor]
+		e.mu.RUnlock()
+
+		if !ok {
+			e.mu.Lock()
+			entities, err := e.loadEntitiesLocked(ctx)
+			if err == nil {
+				descendants, err = e.indexAncestorLocked(ctx, childIdentifiers(entities), ancestor)
+			}
+			e.mu.Unlock()
+			if err != nil {
+				return false, err
+			}
+		}
+
+		if descendants[identifier] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// indexAncestorLocked computes and retains the descendants of ancestor, unless they are already in the ancestor index.
+// It must be called with the write lock held.
+func (e *EntityStore) indexAncestorLocked(ctx context.Context, children map[string][]string, ancestor string) (map[string]bool, error) {
+	if descendants, ok := e.ancestorIndex[ancestor]; ok {
+		return descendants, nil
+	}
+
+	descendants, err := descendantIdentifiers(ctx, children, []string{ancestor})
+	if err != nil {
+		return nil, err
+	}
+	if e.ancestorIndex == nil {
+		e.ancestorIndex = map[string]map[string]bool{}
+	}
+	e.ancestorIndex[ancestor] = descendants
+
+	return descendants, nil
+}
+
+// invalidateAncestorsLocked discards the ancestor index entries of the parents passed in and all of their ancestors,
+// as their descendants change when an entity listing those parents is added or removed. The entities are those before
+// the change, and it must be called with the write lock held.
+func (e *EntityStore) invalidateAncestorsLocked(entities []Entity, parents []string) {
+	if len(e.ancestorIndex) == 0 {
+		return
+	}
+
+	index := e.index
+	if index == nil {
+		index = indexEntities(entities)
+	}
+	pending := append([]string{}, parents...)
+	visited := map[string]bool{}
+	for len(pending) > 0 {
+		parent := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if visited[parent] {
+			continue
+		}
+		visited[parent] = true
+
+		delete(e.ancestorIndex, parent)
+		if i, ok := index[parent]; ok {
+			pending = append(pending, entities[i].Parents...)
+		}
+	}
+}
+
+// descendantIdentifiers returns the identifiers of every base entity below those passed in, walking the hierarchy
+// depth first so that an entity reachable by several paths is not mistaken for a cycle.
+func descendantIdentifiers(ctx context.Context, children map[string][]string, parents []string) (map[string]bool, error) {
+	descendants := map[string]bool{}
+	visited := map[string]bool{}
+	visiting := map[string]bool{} // the entities on the current path
+	var visit func(identifier string) error
+	visit = func(identifier string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		visited[identifier] = true
+		visiting[identifier] = true
+		for _, child := range children[identifier] {
+			if visiting[child] {
+				return &EntityError{Identifier: child, Msg: fmt.Sprintf("cycle detected in the parents of entity %s", child)}
+			}
+			descendants[child] = true
+			if !visited[child] {
+				if err := visit(child); err != nil {
+					return err
 				}
 			}
-			if baseEntity.Identifier == parent {
-				foundEntities[baseEntity.Identifier] = baseEntity
+		}
+		visiting[identifier] = false
+
+		return nil
+	}
+
+	for _, parent := range parents {
+		if !visited[parent] {
+			if err := visit(parent); err != nil {
+				return nil, err
 			}
 		}
-		i++
 	}
 
-	return maps.Values(foundEntities), nil
+	return descendants, nil
 }
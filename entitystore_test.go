@@ -0,0 +1,731 @@
+package polai_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/iann0036/polai"
+)
+
+const testHierarchyEntities = `[
+	{"uid": "Group::\"root\""},
+	{"uid": "Group::\"child\"", "parents": ["Group::\"root\""]},
+	{"uid": "User::\"alice\"", "parents": ["Group::\"child\""]},
+	{"uid": "User::\"bob\"", "parents": ["Group::\"root\""]},
+	{"uid": "User::\"kate\""}
+]`
+
+// entityIdentifiers returns the sorted identifiers of the entities passed in.
+func entityIdentifiers(entities []polai.Entity) []string {
+	identifiers := []string{}
+	for _, entity := range entities {
+		identifiers = append(identifiers, entity.Identifier)
+	}
+	sort.Strings(identifiers)
+	return identifiers
+}
+
+// Ensure the entity store retrieves descendants with and without the starting entities.
+func TestEntityStore_Descendants(t *testing.T) {
+	var tests = []struct {
+		parents             []string
+		expectedDescendents []string
+		expectedTrue        []string
+	}{
+		{
+			parents:             []string{"Group::\"root\""},
+			expectedDescendents: []string{"Group::\"child\"", "Group::\"root\"", "User::\"alice\"", "User::\"bob\""},
+			expectedTrue:        []string{"Group::\"child\"", "User::\"alice\"", "User::\"bob\""},
+		},
+		{
+			parents:             []string{"Group::\"child\"", "Group::\"root\""},
+			expectedDescendents: []string{"Group::\"child\"", "Group::\"root\"", "User::\"alice\"", "User::\"bob\""},
+			expectedTrue:        []string{"Group::\"child\"", "User::\"alice\"", "User::\"bob\""},
+		},
+		{
+			parents:             []string{"User::\"kate\""},
+			expectedDescendents: []string{"User::\"kate\""},
+			expectedTrue:        []string{},
+		},
+	}
+
+	for i, tt := range tests {
+		es := polai.NewEntityStore(strings.NewReader(testHierarchyEntities))
+
+		descendents, err := es.GetEntityDescendents(tt.parents)
+		if err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		}
+		if got := entityIdentifiers(descendents); !reflect.DeepEqual(tt.expectedDescendents, got) {
+			t.Errorf("%d. %v descendents mismatch:\n  exp=%v\n  got=%v\n\n", i, tt.parents, tt.expectedDescendents, got)
+		}
+
+		trueDescendants, err := es.GetTrueDescendants(tt.parents)
+		if err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		}
+		if got := entityIdentifiers(trueDescendants); !reflect.DeepEqual(tt.expectedTrue, got) {
+			t.Errorf("%d. %v true descendants mismatch:\n  exp=%v\n  got=%v\n\n", i, tt.parents, tt.expectedTrue, got)
+		}
+	}
+}
+
+// Ensure traversing the hierarchy stops once the context is done.
+func TestEntityStore_GetEntityDescendentsWithContext(t *testing.T) {
+	es := polai.NewEntityStore(strings.NewReader(testHierarchyEntities))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := es.GetEntityDescendentsWithContext(ctx, []string{"Group::\"root\""}); !errors.Is(err, context.Canceled) {
+		t.Errorf("error mismatch: exp=%s got=%v", context.Canceled, err)
+	}
+
+	descendents, err := es.GetEntityDescendentsWithContext(context.Background(), []string{"Group::\"child\""})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if exp, got := []string{"Group::\"child\"", "User::\"alice\""}, entityIdentifiers(descendents); !reflect.DeepEqual(exp, got) {
+		t.Errorf("descendents mismatch:\n  exp=%v\n  got=%v", exp, got)
+	}
+}
+
+// Ensure entities added to a loaded store are visible to lookups and evaluation.
+func TestEntityStore_AddEntity(t *testing.T) {
+	es := polai.NewEntityStore(strings.NewReader(testHierarchyEntities))
+	if err := es.BuildIndex(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := es.AddEntity(polai.Entity{Identifier: "User::\"carol\"", Parents: []string{"Group::\"child\""}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	entities, err := es.GetEntities()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if exp, got := 6, len(entities); exp != got {
+		t.Errorf("entity count mismatch: exp=%d got=%d", exp, got)
+	}
+	descendents, err := es.GetEntityDescendents([]string{"Group::\"child\""})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if exp, got := []string{"Group::\"child\"", "User::\"alice\"", "User::\"carol\""}, entityIdentifiers(descendents); !reflect.DeepEqual(exp, got) {
+		t.Errorf("descendents mismatch:\n  exp=%v\n  got=%v", exp, got)
+	}
+
+	var entityErr *polai.EntityError
+	if err := es.AddEntity(polai.Entity{Identifier: "User::\"carol\""}); !errors.As(err, &entityErr) {
+		t.Errorf("expected error adding duplicate entity, got %v", err)
+	}
+	for _, identifier := range []string{"carol", "User::carol", "::\"carol\"", "1User::\"carol\"", "User::\"carol"} {
+		if err := es.AddEntity(polai.Entity{Identifier: identifier}); !errors.As(err, &entityErr) {
+			t.Errorf("expected error adding entity %s, got %v", identifier, err)
+		}
+	}
+
+	es.OverwriteEntities = true
+	if err := es.AddEntity(polai.Entity{Identifier: "User::\"carol\""}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if descendents, err = es.GetEntityDescendents([]string{"Group::\"child\""}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if exp, got := []string{"Group::\"child\"", "User::\"alice\""}, entityIdentifiers(descendents); !reflect.DeepEqual(exp, got) {
+		t.Errorf("descendents mismatch after overwrite:\n  exp=%v\n  got=%v", exp, got)
+	}
+
+	level := int64(3)
+	e := polai.MustNewEvaluator(`permit (principal, action, resource) when { principal.level == 3 };`)
+	e.SetEntities(strings.NewReader(`[]`))
+	if err := e.AddEntity(polai.Entity{Identifier: "User::\"carol\"", Attributes: []polai.Attribute{{Name: "level", LongValue: &level}}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	result, err := e.Evaluate(`User::"carol"`, `Action::"view"`, `Folder::"root"`, `{}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !result {
+		t.Errorf("result mismatch: exp=true got=false")
+	}
+}
+
+// Ensure entities removed from a loaded store are no longer visible to lookups.
+func TestEntityStore_RemoveEntity(t *testing.T) {
+	es := polai.NewEntityStore(strings.NewReader(testHierarchyEntities))
+
+	if err := es.RemoveEntity("Group::\"child\""); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	descendents, err := es.GetEntityDescendents([]string{"Group::\"root\""})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if exp, got := []string{"Group::\"root\"", "User::\"bob\""}, entityIdentifiers(descendents); !reflect.DeepEqual(exp, got) {
+		t.Errorf("descendents mismatch:\n  exp=%v\n  got=%v", exp, got)
+	}
+
+	if err := es.RemoveEntity("User::\"kate\""); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	entities, err := es.GetEntities()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if exp, got := []string{"Group::\"root\"", "User::\"alice\"", "User::\"bob\""}, entityIdentifiers(entities); !reflect.DeepEqual(exp, got) {
+		t.Errorf("entities mismatch:\n  exp=%v\n  got=%v", exp, got)
+	}
+
+	if err := es.RemoveEntity("User::\"kate\""); !errors.Is(err, polai.ErrEntityNotFound) {
+		t.Errorf("error mismatch: exp=%s got=%v", polai.ErrEntityNotFound, err)
+	}
+}
+
+// Ensure individual entities are retrieved by identifier, including after the entities change.
+func TestEntityStore_GetEntity(t *testing.T) {
+	es := polai.NewEntityStore(strings.NewReader(testHierarchyEntities))
+
+	entity, found, err := es.GetEntity("User::\"alice\"")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !found || !reflect.DeepEqual([]string{"Group::\"child\""}, entity.Parents) {
+		t.Errorf("entity mismatch: found=%v entity=%#v", found, entity)
+	}
+	if _, found, err := es.GetEntity("User::\"carol\""); err != nil || found {
+		t.Errorf("expected User::\"carol\" not to be found, got found=%v err=%v", found, err)
+	}
+
+	if err := es.AddEntity(polai.Entity{Identifier: "User::\"carol\""}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, found, err := es.GetEntity("User::\"carol\""); err != nil || !found {
+		t.Errorf("expected User::\"carol\" to be found after adding, got found=%v err=%v", found, err)
+	}
+	if err := es.RemoveEntity("User::\"alice\""); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, found, err := es.GetEntity("User::\"alice\""); err != nil || found {
+		t.Errorf("expected User::\"alice\" not to be found after removing, got found=%v err=%v", found, err)
+	}
+	es.SetEntities(strings.NewReader(`[{"uid": "User::\"dave\""}]`))
+	if _, found, err := es.GetEntity("User::\"dave\""); err != nil || !found {
+		t.Errorf("expected User::\"dave\" to be found after overriding, got found=%v err=%v", found, err)
+	}
+}
+
+// Ensure cycles in the parents of entities are reported, without rejecting entities reachable by several paths.
+func TestEntityStore_DescendantsCycle(t *testing.T) {
+	var tests = []struct {
+		name     string
+		entities string
+		parents  []string
+		cycle    bool
+	}{
+		{
+			name: "two entity cycle",
+			entities: `[
+				{"uid": "Group::\"a\"", "parents": ["Group::\"b\""]},
+				{"uid": "Group::\"b\"", "parents": ["Group::\"a\""]}
+			]`,
+			parents: []string{"Group::\"a\""},
+			cycle:   true,
+		},
+		{
+			name: "three entity cycle",
+			entities: `[
+				{"uid": "Group::\"a\"", "parents": ["Group::\"c\""]},
+				{"uid": "Group::\"b\"", "parents": ["Group::\"a\""]},
+				{"uid": "Group::\"c\"", "parents": ["Group::\"b\""]},
+				{"uid": "User::\"alice\"", "parents": ["Group::\"c\""]}
+			]`,
+			parents: []string{"Group::\"b\""},
+			cycle:   true,
+		},
+		{
+			name: "deep hierarchy with shared descendants",
+			entities: `[
+				{"uid": "Group::\"root\""},
+				{"uid": "Group::\"a\"", "parents": ["Group::\"root\""]},
+				{"uid": "Group::\"b\"", "parents": ["Group::\"root\""]},
+				{"uid": "Group::\"c\"", "parents": ["Group::\"a\"", "Group::\"b\""]},
+				{"uid": "Group::\"d\"", "parents": ["Group::\"c\""]},
+				{"uid": "User::\"alice\"", "parents": ["Group::\"d\"", "Group::\"a\""]}
+			]`,
+			parents: []string{"Group::\"root\"", "Group::\"c\""},
+			cycle:   false,
+		},
+	}
+
+	for i, tt := range tests {
+		es := polai.NewEntityStore(strings.NewReader(tt.entities))
+
+		_, err := es.GetEntityDescendents(tt.parents)
+		var entityErr *polai.EntityError
+		if tt.cycle && !errors.As(err, &entityErr) {
+			t.Errorf("%d. %s: expected cycle error, got %v", i, tt.name, err)
+		} else if !tt.cycle && err != nil {
+			t.Errorf("%d. %s: unexpected error: %s", i, tt.name, err)
+		}
+
+		_, err = es.GetTrueDescendants(tt.parents)
+		if tt.cycle && !errors.As(err, &entityErr) {
+			t.Errorf("%d. %s: expected cycle error from true descendants, got %v", i, tt.name, err)
+		} else if !tt.cycle && err != nil {
+			t.Errorf("%d. %s: unexpected error from true descendants: %s", i, tt.name, err)
+		}
+	}
+}
+
+// Ensure entities read from further sources are merged with the existing entities.
+func TestEntityStore_MergeEntities(t *testing.T) {
+	es := polai.NewEntityStore(strings.NewReader(`[{"uid": "User::\"alice\"", "parents": ["Group::\"admins\""]}]`))
+	if err := es.MergeEntities(strings.NewReader(`[{"uid": "Group::\"admins\""}, {"uid": "User::\"bob\""}]`)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	entities, err := es.GetEntities()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if exp, got := []string{"Group::\"admins\"", "User::\"alice\"", "User::\"bob\""}, entityIdentifiers(entities); !reflect.DeepEqual(exp, got) {
+		t.Errorf("entities mismatch:\n  exp=%v\n  got=%v", exp, got)
+	}
+
+	var entityErr *polai.EntityError
+	if err := es.MergeEntities(strings.NewReader(`[{"uid": "User::\"carol\""}, {"uid": "User::\"alice\""}]`)); !errors.As(err, &entityErr) {
+		t.Errorf("expected error merging duplicate entity, got %v", err)
+	}
+	if _, found, err := es.GetEntity("User::\"carol\""); err != nil || found {
+		t.Errorf("expected failed merge to leave entities unchanged, got found=%v err=%v", found, err)
+	}
+	if err := es.MergeEntities(strings.NewReader(`[{"uid": "User::\"carol\"`)); !errors.As(err, &entityErr) {
+		t.Errorf("expected error merging invalid json, got %v", err)
+	}
+	if err := es.MergeEntities(strings.NewReader(`[{"uid": "User::\"carol\""}, {"uid": "User::\"carol\""}]`)); !errors.As(err, &entityErr) {
+		t.Errorf("expected error merging entity duplicated within the input, got %v", err)
+	}
+
+	es.OverwriteEntities = true
+	if err := es.MergeEntities(strings.NewReader(`[{"uid": "User::\"carol\""}, {"uid": "User::\"alice\""}]`)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	alice, found, err := es.GetEntity("User::\"alice\"")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !found || len(alice.Parents) != 0 {
+		t.Errorf("expected later definition of User::\"alice\" to win, got %#v", alice)
+	}
+
+	if err := es.MergeEntities(strings.NewReader(`[{"uid": "User::\"dave\""}, {"uid": "User::\"dave\"", "parents": ["Group::\"admins\""]}]`)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	entities, err = es.GetEntities()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if exp, got := []string{"Group::\"admins\"", "User::\"alice\"", "User::\"bob\"", "User::\"carol\"", "User::\"dave\""}, entityIdentifiers(entities); !reflect.DeepEqual(exp, got) {
+		t.Errorf("entities mismatch:\n  exp=%v\n  got=%v", exp, got)
+	}
+	if dave, _, err := es.GetEntity("User::\"dave\""); err != nil || len(dave.Parents) != 1 {
+		t.Errorf("expected later definition of User::\"dave\" within the input to win, got %#v (%v)", dave, err)
+	}
+
+	e := polai.MustNewEvaluator(`permit (principal in Group::"admins", action, resource);`)
+	e.SetEntities(strings.NewReader(`[{"uid": "User::\"alice\"", "parents": ["Group::\"admins\""]}]`))
+	if err := e.MergeEntities(strings.NewReader(`[{"uid": "User::\"bob\"", "parents": ["Group::\"admins\""]}]`)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, principal := range []string{`User::"alice"`, `User::"bob"`} {
+		result, err := e.Evaluate(principal, `Action::"view"`, `Folder::"root"`, `{}`)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !result {
+			t.Errorf("%s: result mismatch: exp=true got=false", principal)
+		}
+	}
+}
+
+// staticEntitySource is an EntitySource returning a fixed list of entities, counting how often it is called.
+type staticEntitySource struct {
+	entities []polai.Entity
+	calls    int
+}
+
+func (s *staticEntitySource) GetEntities(ctx context.Context) ([]polai.Entity, error) {
+	s.calls++
+	return s.entities, nil
+}
+
+// errorEntitySource is an EntitySource which always fails.
+type errorEntitySource struct{}
+
+func (errorEntitySource) GetEntities(ctx context.Context) ([]polai.Entity, error) {
+	return nil, errors.New("backend unavailable")
+}
+
+// Ensure entities are retrieved from a custom entity source.
+func TestEntityStore_EntitySource(t *testing.T) {
+	level := int64(3)
+	src := &staticEntitySource{entities: []polai.Entity{
+		{Identifier: "User::\"alice\"", Parents: []string{"Group::\"admins\""}, Attributes: []polai.Attribute{{Name: "level", LongValue: &level}}},
+		{Identifier: "Group::\"admins\""},
+	}}
+
+	e := polai.MustNewEvaluator(`permit (principal in Group::"admins", action, resource) when { principal.level > 2 };`)
+	e.SetEntitySource(src)
+	for i := 0; i < 2; i++ {
+		result, err := e.Evaluate(`User::"alice"`, `Action::"view"`, `Folder::"root"`, `{}`)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !result {
+			t.Errorf("result mismatch: exp=true got=false")
+		}
+	}
+	if exp, got := 1, src.calls; exp != got {
+		t.Errorf("entity source call count mismatch: exp=%d got=%d", exp, got)
+	}
+
+	es := polai.NewEntityStoreFromSource(errorEntitySource{})
+	if _, err := es.GetEntities(); err == nil || err.Error() != "backend unavailable" {
+		t.Errorf("error mismatch: exp=backend unavailable got=%v", err)
+	}
+	es.SetEntitySource(src)
+	descendents, err := es.GetEntityDescendents([]string{"Group::\"admins\""})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if exp, got := []string{"Group::\"admins\"", "User::\"alice\""}, entityIdentifiers(descendents); !reflect.DeepEqual(exp, got) {
+		t.Errorf("descendents mismatch:\n  exp=%v\n  got=%v", exp, got)
+	}
+}
+
+// Ensure the entities may be changed whilst requests are being evaluated. Run with -race to detect data races.
+func TestEntityStore_Concurrency(t *testing.T) {
+	e := polai.MustNewEvaluator(`permit (principal in Group::"admins", action, resource) when { principal.level > 2 };`)
+	e.SetEntities(strings.NewReader(testHierarchyEntities))
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if _, err := e.Evaluate(`User::"alice"`, `Action::"view"`, `Folder::"root"`, `{}`); err != nil && !strings.Contains(err.Error(), "attribute not set") {
+					t.Errorf("unexpected error: %s", err)
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			switch i % 4 {
+			case 0:
+				e.SetEntities(strings.NewReader(fmt.Sprintf(`[{"uid": "User::\"alice\"", "parents": ["Group::\"admins\""], "attrs": {"level": %d}}]`, i)))
+			case 1:
+				_ = e.AddEntity(polai.Entity{Identifier: fmt.Sprintf("User::\"%d\"", i)})
+			case 2:
+				_ = e.RemoveEntity(fmt.Sprintf("User::\"%d\"", i-1))
+			case 3:
+				_ = e.BuildEntityIndex()
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+// Ensure the ancestors of entities are retrieved, and cycles in their parents reported.
+func TestEntityStore_GetEntityAncestors(t *testing.T) {
+	var tests = []struct {
+		name     string
+		entities string
+		entity   string
+		expected []string
+		cycle    bool
+	}{
+		{
+			name:     "three level hierarchy",
+			entities: testHierarchyEntities,
+			entity:   "User::\"alice\"",
+			expected: []string{"Group::\"child\"", "Group::\"root\""},
+		},
+		{
+			name: "diamond hierarchy",
+			entities: `[
+				{"uid": "Group::\"root\""},
+				{"uid": "Group::\"a\"", "parents": ["Group::\"root\""]},
+				{"uid": "Group::\"b\"", "parents": ["Group::\"root\"", "Group::\"missing\""]},
+				{"uid": "User::\"alice\"", "parents": ["Group::\"a\"", "Group::\"b\""]}
+			]`,
+			entity:   "User::\"alice\"",
+			expected: []string{"Group::\"a\"", "Group::\"b\"", "Group::\"root\""},
+		},
+		{
+			name:     "no parents",
+			entities: testHierarchyEntities,
+			entity:   "User::\"kate\"",
+			expected: []string{},
+		},
+		{
+			name:     "unknown entity",
+			entities: testHierarchyEntities,
+			entity:   "User::\"nobody\"",
+			expected: []string{},
+		},
+		{
+			name: "cycle",
+			entities: `[
+				{"uid": "Group::\"a\"", "parents": ["Group::\"b\""]},
+				{"uid": "Group::\"b\"", "parents": ["Group::\"a\""]},
+				{"uid": "User::\"alice\"", "parents": ["Group::\"a\""]}
+			]`,
+			entity: "User::\"alice\"",
+			cycle:  true,
+		},
+	}
+
+	for i, tt := range tests {
+		es := polai.NewEntityStore(strings.NewReader(tt.entities))
+
+		ancestors, err := es.GetEntityAncestors(tt.entity)
+		if tt.cycle {
+			var entityErr *polai.EntityError
+			if !errors.As(err, &entityErr) {
+				t.Errorf("%d. %s: expected cycle error, got %v", i, tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d. %s: unexpected error: %s", i, tt.name, err)
+		}
+		if got := entityIdentifiers(ancestors); !reflect.DeepEqual(tt.expected, got) {
+			t.Errorf("%d. %s: ancestors mismatch:\n  exp=%v\n  got=%v\n\n", i, tt.name, tt.expected, got)
+		}
+	}
+}
+
+// Ensure ancestry is answered from the ancestor index, which follows entities being added and removed.
+func TestEntityStore_IsAncestor(t *testing.T) {
+	es := polai.NewEntityStore(strings.NewReader(testHierarchyEntities))
+	if err := es.BuildAncestorIndex(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	type ancestry struct {
+		ancestor   string
+		descendant string
+		exp        bool
+	}
+	check := func(step string, tests []ancestry) {
+		for i, tt := range tests {
+			if got := es.IsAncestor(tt.ancestor, tt.descendant); got != tt.exp {
+				t.Errorf("%s %d. IsAncestor(%s, %s) mismatch: exp=%v got=%v", step, i, tt.ancestor, tt.descendant, tt.exp, got)
+			}
+		}
+	}
+	check("initial", []ancestry{
+		{ancestor: "Group::\"root\"", descendant: "User::\"alice\"", exp: true},
+		{ancestor: "Group::\"root\"", descendant: "Group::\"child\"", exp: true},
+		{ancestor: "Group::\"child\"", descendant: "User::\"bob\"", exp: false},
+		{ancestor: "User::\"alice\"", descendant: "Group::\"root\"", exp: false},
+		{ancestor: "Group::\"root\"", descendant: "Group::\"root\"", exp: false},
+		{ancestor: "Group::\"root\"", descendant: "User::\"kate\"", exp: false},
+		{ancestor: "Group::\"missing\"", descendant: "User::\"alice\"", exp: false},
+	})
+
+	if err := es.AddEntity(polai.Entity{Identifier: "User::\"carol\"", Parents: []string{"Group::\"child\""}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := es.RemoveEntity("Group::\"child\""); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	es.OverwriteEntities = true
+	if err := es.AddEntity(polai.Entity{Identifier: "User::\"kate\"", Parents: []string{"User::\"bob\""}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	check("updated", []ancestry{
+		{ancestor: "Group::\"child\"", descendant: "User::\"carol\"", exp: true},
+		{ancestor: "Group::\"root\"", descendant: "User::\"carol\"", exp: false},
+		{ancestor: "Group::\"root\"", descendant: "User::\"alice\"", exp: false},
+		{ancestor: "Group::\"root\"", descendant: "User::\"kate\"", exp: true},
+		{ancestor: "User::\"bob\"", descendant: "User::\"kate\"", exp: true},
+	})
+
+	es.SetEntities(strings.NewReader(`[
+		{"uid": "Group::\"a\"", "parents": ["Group::\"b\""]},
+		{"uid": "Group::\"b\"", "parents": ["Group::\"a\""]}
+	]`))
+	var entityErr *polai.EntityError
+	if err := es.BuildAncestorIndex(); !errors.As(err, &entityErr) {
+		t.Errorf("expected cycle error, got %v", err)
+	}
+	if es.IsAncestor("Group::\"a\"", "Group::\"b\"") {
+		t.Errorf("expected no ancestry within a cycle")
+	}
+}
+
+// Ensure the entity store parses entity reference attributes.
+func TestEntityStore_EntityReferenceAttribute(t *testing.T) {
+	es := polai.NewEntityStore(strings.NewReader(`[
+		{"uid": "Task::\"t1\"", "attrs": {"owner": {"__entity": {"type": "Org::User", "id": "alice"}}}}
+	]`))
+
+	entities, err := es.GetEntities()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(entities) != 1 || len(entities[0].Attributes) != 1 || entities[0].Attributes[0].EntityValue == nil {
+		t.Fatalf("expected single entity reference attribute, got %#v", entities)
+	}
+	if exp, got := "Org::User::\"alice\"", *entities[0].Attributes[0].EntityValue; exp != got {
+		t.Errorf("entity reference mismatch:\n  exp=%s\n  got=%s", exp, got)
+	}
+	if entities[0].Attributes[0].RecordValue != nil {
+		t.Errorf("unexpected record value for entity reference attribute")
+	}
+
+	// entity references added directly are resolved in the same way
+	manager := "Org::User::\"bob\""
+	if err := es.AddEntity(polai.Entity{Identifier: "Task::\"t2\"", Attributes: []polai.Attribute{{Name: "owner", EntityValue: &manager}}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	entity, found, err := es.GetEntity("Task::\"t2\"")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !found || len(entity.Attributes) != 1 || entity.Attributes[0].EntityValue == nil || *entity.Attributes[0].EntityValue != manager {
+		t.Errorf("expected entity reference %s to be retained, got %#v", manager, entity)
+	}
+}
+
+// Ensure an indexed entity store resolves the same entities as an unindexed one.
+func TestEntityStore_BuildIndex(t *testing.T) {
+	for _, buildIndex := range []bool{false, true} {
+		e := polai.MustNewEvaluator(`permit (principal, action, resource) when { principal.level == 3 && resource.level == 1 };`)
+		e.SetEntities(strings.NewReader(`[
+			{"uid": "User::\"zed\"", "attrs": {"level": 3}},
+			{"uid": "User::\"alice\"", "attrs": {"level": 2}},
+			{"uid": "Folder::\"root\"", "attrs": {"level": 1}}
+		]`))
+		if buildIndex {
+			if err := e.BuildEntityIndex(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+
+		result, err := e.Evaluate(`User::"zed"`, `Action::"view"`, `Folder::"root"`, `{}`)
+		if err != nil {
+			t.Fatalf("index=%v: unexpected error: %s", buildIndex, err)
+		}
+		if !result {
+			t.Errorf("index=%v: result mismatch: exp=true got=false", buildIndex)
+		}
+	}
+
+	es := polai.NewEntityStore(strings.NewReader(testHierarchyEntities))
+	if err := es.BuildIndex(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	entities, err := es.GetEntities()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	identifiers := []string{}
+	for _, entity := range entities {
+		identifiers = append(identifiers, entity.Identifier)
+	}
+	if !sort.StringsAreSorted(identifiers) {
+		t.Errorf("expected entities to be sorted by identifier, got %v", identifiers)
+	}
+}
+
+// benchmarkGetEntity retrieves the last of n entities, either with GetEntity or by scanning every entity.
+func benchmarkGetEntity(b *testing.B, n int, scan bool) {
+	var entities []string
+	for i := 0; i < n; i++ {
+		entities = append(entities, fmt.Sprintf(`{"uid": "User::\"%d\""}`, i))
+	}
+	es := polai.NewEntityStore(strings.NewReader("[" + strings.Join(entities, ",") + "]"))
+	identifier := fmt.Sprintf(`User::"%d"`, n-1)
+	if _, _, err := es.GetEntity(identifier); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		found := false
+		if scan {
+			all, err := es.GetEntities()
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, entity := range all {
+				if entity.Identifier == identifier {
+					found = true
+					break
+				}
+			}
+		} else {
+			var err error
+			if _, found, err = es.GetEntity(identifier); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if !found {
+			b.Fatalf("entity %s not found", identifier)
+		}
+	}
+}
+
+// benchmarkAncestry checks whether a leaf of a tree of 1,000 entities is below the root, either with IsAncestor or by
+// traversing the hierarchy for the descendants of the root.
+func benchmarkAncestry(b *testing.B, traverse bool) {
+	entities := []string{`{"uid": "Group::\"0\""}`}
+	for i := 1; i < 1000; i++ {
+		entities = append(entities, fmt.Sprintf(`{"uid": "Group::\"%d\"", "parents": ["Group::\"%d\""]}`, i, (i-1)/4))
+	}
+	es := polai.NewEntityStore(strings.NewReader("[" + strings.Join(entities, ",") + "]"))
+	if err := es.BuildAncestorIndex(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		found := false
+		if traverse {
+			descendants, err := es.GetEntityDescendents([]string{`Group::"0"`})
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, entity := range descendants {
+				if entity.Identifier == `Group::"999"` {
+					found = true
+					break
+				}
+			}
+		} else {
+			found = es.IsAncestor(`Group::"0"`, `Group::"999"`)
+		}
+		if !found {
+			b.Fatal("expected ancestry")
+		}
+	}
+}
+
+func BenchmarkAncestry_Traverse1k(b *testing.B) { benchmarkAncestry(b, true) }
+func BenchmarkAncestry_Indexed1k(b *testing.B)  { benchmarkAncestry(b, false) }
+
+func BenchmarkGetEntity_Scan10k(b *testing.B)     { benchmarkGetEntity(b, 10000, true) }
+func BenchmarkGetEntity_Indexed10k(b *testing.B)  { benchmarkGetEntity(b, 10000, false) }
+func BenchmarkGetEntity_Scan100k(b *testing.B)    { benchmarkGetEntity(b, 100000, true) }
+func BenchmarkGetEntity_Indexed100k(b *testing.B) { benchmarkGetEntity(b, 100000, false) }
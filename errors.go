@@ -0,0 +1,83 @@
+package polai
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrEntityNotFound is returned when removing an entity which is not in the entity store.
+var ErrEntityNotFound = errors.New("entity not found")
+
+// ParseError represents a syntax error found whilst parsing a policy, along with the
+// position of the token that caused it.
+type ParseError struct {
+	Pos
+	Msg string
+}
+
+// Error returns the message along with the line and column of the error.
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at line %d, column %d", e.Msg, e.Line, e.Col)
+}
+
+// ParseErrors represents every syntax error found whilst parsing a set of policies.
+type ParseErrors []*ParseError
+
+// Error returns each error message, separated by semicolons.
+func (e ParseErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// EvalError represents an error encountered whilst evaluating a policy condition. Expr holds the condition
+// expression being evaluated, where known.
+type EvalError struct {
+	Expr string
+	Msg  string
+}
+
+// Error returns the message of the error.
+func (e *EvalError) Error() string {
+	return e.Msg
+}
+
+// EntityError represents an invalid entity or entity store. Identifier holds the entity identifier involved, where
+// known.
+type EntityError struct {
+	Identifier string
+	Msg        string
+}
+
+// Error returns the message of the error.
+func (e *EntityError) Error() string {
+	return e.Msg
+}
+
+// AttributeError represents a failed attribute lookup. Entity holds the identifier of the entity the attribute was
+// accessed on, and is empty when the attribute was accessed on the context or a record.
+type AttributeError struct {
+	Entity    string
+	Attribute string
+	Msg       string
+}
+
+// Error returns the message of the error.
+func (e *AttributeError) Error() string {
+	return e.Msg
+}
+
+// ValidationError represents a semantic problem found within a policy statement without evaluating it.
+type ValidationError struct {
+	StatementIndex int
+	Msg            string
+}
+
+// Error returns the message along with the index of the statement.
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s in statement %d", e.Msg, e.StatementIndex)
+}
@@ -1,15 +1,21 @@
 package polai
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
+	"os"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 
 	match "github.com/iann0036/match-wildcard"
+	"golang.org/x/exp/maps"
 )
 
 var OP_PRECEDENCE = map[Token]int{
@@ -22,11 +28,16 @@ var OP_PRECEDENCE = map[Token]int{
 	GT:          3,
 	GTE:         3,
 	IN:          3,
+	IS:          3,
+	HAS:         3,
 	LIKE:        3,
 	PLUS:        4,
 	DASH:        4,
 	MULTIPLIER:  5,
+	SLASH:       5,
+	PERCENT:     5,
 	EXCLAMATION: 5,
+	UNARYMINUS:  5,
 	PERIOD:      6,
 	FUNCTION:    7,
 	RIGHT_SQB:   7,
@@ -40,9 +51,14 @@ var LEFT_ASSOCIATIVE = map[Token]bool{
 	GT:          true,
 	GTE:         true,
 	IN:          true,
+	IS:          true,
+	HAS:         true,
 	LIKE:        true,
+	PLUS:        true,
 	DASH:        true,
-	EXCLAMATION: true,
+	MULTIPLIER:  true,
+	SLASH:       true,
+	PERCENT:     true,
 	PERIOD:      true,
 	FUNCTION:    true,
 	RIGHT_SQB:   true,
@@ -51,17 +67,165 @@ var LEFT_ASSOCIATIVE = map[Token]bool{
 
 // Evaluator represents an evaluator.
 type Evaluator struct {
-	p                    *Parser
-	es                   *EntityStore
+	p                *Parser
+	es               *EntityStore
+	policyStatements PolicySet
+	parseErr         error
+	parsed           bool
+	unrereadable     bool // the policy reader has been closed, or the statements were bound from another evaluator
+	opts             Options
+	constructors     map[string]extensionConstructor
+	methods          map[extensionMethodKey]ExtensionMethod
+	fallbackMethods  map[string]ExtensionMethod
+
+	// AllowShortCircuiting skips the right-hand operand of && and || once the left-hand operand decides the result,
+	// and the unused branch of if-then-else. With it disabled, errors within those skipped operands are reported,
+	// but the result is otherwise the same. It does not apply to the scope, which stops at the first of principal,
+	// action and resource that does not match, nor to the policy, which stops at the first matching forbid, or
+	// permit when no forbid matches. In both cases the conditions of statements not reached are never evaluated.
+	// The default is true.
 	AllowShortCircuiting bool
+
+	// Concurrency is the number of goroutines EvaluateBatch splits requests across. Values below 2 evaluate
+	// requests serially.
+	Concurrency int
+
+	// LikeComplexityLimit limits the backtracking of like pattern matches, per character of the string matched.
+	// Matches exceeding it fail with a "string match too complex" error. A value of 0 or less removes the limit.
+	// The default is 100.
+	LikeComplexityLimit int
+
+	// DecimalPrecision is the maximum number of fractional digits accepted by the decimal() function. A value of 0
+	// or less removes the limit. The default is 4, as specified by Cedar.
+	DecimalPrecision int
+}
+
+// EvaluationRequest represents a single authorization request. The principal, action and resource are entity
+// identifiers, e.g. User::"alice", and the context is a JSON object. An empty context is treated as "{}".
+type EvaluationRequest struct {
+	Principal string
+	Action    string
+	Resource  string
+	Context   string
+}
+
+// EvaluationResult represents the outcome of an authorization request.
+type EvaluationResult struct {
+	Permitted bool
+	Err       error
+
+	// Effect and PolicyIndex identify the statement which decided the request. When no statement matched and
+	// the request was implicitly denied, Effect is ILLEGAL and PolicyIndex is -1. Conditions is only set by
+	// EvaluateExplained.
+	Effect      Token
+	PolicyIndex int
+	Conditions  []ConditionResult
+}
+
+// ConditionResult represents the result of evaluating a single condition clause.
+type ConditionResult struct {
+	PolicyIndex int
+	ClauseType  Token
+	Outcome     bool
+	Error       error
 }
 
 // NewEvaluator returns a new instance of Evaluator.
-func NewEvaluator(policyReader io.Reader) *Evaluator {
-	return &Evaluator{
-		p:                    NewParser(policyReader),
+func NewEvaluator(policyReader io.Reader, opts ...Option) *Evaluator {
+	e := &Evaluator{
+		p:                    NewParser(policyReader, opts...),
+		opts:                 newOptions(opts...),
 		AllowShortCircuiting: true,
+		LikeComplexityLimit:  100,
+		DecimalPrecision:     4,
+	}
+	e.registerBuiltinExtensions()
+
+	return e
+}
+
+// NewEvaluatorFromString returns a new instance of Evaluator for the provided policy text.
+func NewEvaluatorFromString(policyText string, opts ...Option) *Evaluator {
+	return NewEvaluator(strings.NewReader(policyText), opts...)
+}
+
+// NewEvaluatorFromFile returns a new instance of Evaluator for the policy file at path. The policy is parsed
+// immediately, so that the file is closed before returning, and an error is returned if it cannot be read or parsed.
+func NewEvaluatorFromFile(path string, opts ...Option) (*Evaluator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	e := NewEvaluator(f, opts...)
+	if err := e.WarmUp(); err != nil {
+		return nil, err
+	}
+	e.unrereadable = true
+
+	return e, nil
+}
+
+// MustNewEvaluator is like NewEvaluatorFromString but parses the policy immediately, panicking if it cannot be parsed.
+// It simplifies safe initialization of evaluators for static, compile-time known policies and should not be used
+// with policies provided at runtime.
+func MustNewEvaluator(policyText string) *Evaluator {
+	e := NewEvaluatorFromString(policyText)
+	if err := e.WarmUp(); err != nil {
+		panic(err)
+	}
+
+	return e
+}
+
+// WarmUp parses the policy and retains the statements, so that subsequent evaluations do not parse it again. If
+// the policy cannot be parsed, the error is retained and returned by every subsequent evaluation instead.
+func (e *Evaluator) WarmUp() error {
+	if e.parsed {
+		return e.parseErr
+	}
+
+	e.policyStatements, e.parseErr = e.p.Parse()
+	e.parsed = true
+
+	return e.parseErr
+}
+
+// PreParse parses the policy and caches the statements for subsequent evaluations.
+//
+// Deprecated: Use WarmUp, which is equivalent.
+func (e *Evaluator) PreParse() error {
+	return e.WarmUp()
+}
+
+// Reset clears the cached policy statements, so that the policy is parsed again from the policy reader on the next
+// evaluation. It should be called after repositioning the underlying policy reader. An error is returned, and the
+// statements are retained, if the policy cannot be read again, as for evaluators returned by NewEvaluatorFromFile
+// or BindSlots.
+func (e *Evaluator) Reset() error {
+	if e.unrereadable {
+		return &EvalError{Msg: "policy reader cannot be read again, use SetPolicyReader instead"}
 	}
+
+	e.clearPolicy()
+	return nil
+}
+
+// SetPolicyReader replaces the policy of the evaluator, e.g. when reloading an updated policy. The cached policy
+// statements are cleared and the entity store is preserved.
+func (e *Evaluator) SetPolicyReader(policyReader io.Reader) {
+	e.p = NewParser(policyReader)
+	e.p.opts = e.opts
+	e.unrereadable = false
+	e.clearPolicy()
+}
+
+// clearPolicy clears the cached policy statements and parse error.
+func (e *Evaluator) clearPolicy() {
+	e.policyStatements = nil
+	e.parseErr = nil
+	e.parsed = false
 }
 
 func (e *Evaluator) SetEntities(entityReader io.Reader) {
@@ -72,262 +236,536 @@ func (e *Evaluator) SetEntities(entityReader io.Reader) {
 	}
 }
 
-func (e *Evaluator) Evaluate(principal, action, resource, context string) (bool, error) {
-	policyStatements, err := e.p.Parse()
+// SetEntitySource overrides the entities of the evaluator with those retrieved from src.
+func (e *Evaluator) SetEntitySource(src EntitySource) {
+	if e.es == nil {
+		e.es = NewEntityStoreFromSource(src)
+	} else {
+		e.es.SetEntitySource(src)
+	}
+}
+
+// MergeEntities adds further entities to the entities of the evaluator. See EntityStore.MergeEntities.
+func (e *Evaluator) MergeEntities(entityReader io.Reader) error {
+	if e.es == nil {
+		e.es = NewEntityStore(strings.NewReader("[]"))
+	}
+
+	return e.es.MergeEntities(entityReader)
+}
+
+// AddEntity adds a single entity to the entities of the evaluator. See EntityStore.AddEntity.
+func (e *Evaluator) AddEntity(entity Entity) error {
+	if e.es == nil {
+		e.es = NewEntityStore(strings.NewReader("[]"))
+	}
+
+	return e.es.AddEntity(entity)
+}
+
+// RemoveEntity removes an entity from the entities of the evaluator. See EntityStore.RemoveEntity.
+func (e *Evaluator) RemoveEntity(identifier string) error {
+	if e.es == nil {
+		return ErrEntityNotFound
+	}
+
+	return e.es.RemoveEntity(identifier)
+}
+
+// BuildEntityIndex indexes the entities of the evaluator by identifier. See EntityStore.BuildIndex.
+func (e *Evaluator) BuildEntityIndex() error {
+	if e.es == nil {
+		return &EntityError{Msg: "no entities available"}
+	}
+
+	return e.es.BuildIndex()
+}
+
+func (e *Evaluator) Evaluate(principal, action, resource, contextStr string) (bool, error) {
+	result := e.EvaluateRequest(EvaluationRequest{
+		Principal: principal,
+		Action:    action,
+		Resource:  resource,
+		Context:   contextStr,
+	})
+
+	return result.Permitted, result.Err
+}
+
+// EvaluateRequest evaluates an authorization request, returning whether it is permitted along with the statement
+// which decided it. Any error is set on the result.
+func (e *Evaluator) EvaluateRequest(req EvaluationRequest) EvaluationResult {
+	result, err := e.evaluate(context.Background(), req.Principal, req.Action, req.Resource, req.Context, nil)
+	result.Err = err
+
+	return result
+}
+
+// EvaluateWithContext is like Evaluate, but stops evaluating and returns the context error once ctx is cancelled
+// or its deadline passes.
+func (e *Evaluator) EvaluateWithContext(ctx context.Context, principal, action, resource, contextStr string) (bool, error) {
+	result, err := e.evaluate(ctx, principal, action, resource, contextStr, nil)
+	return result.Permitted, err
+}
+
+// EvaluateExplained is like Evaluate, but also returns which statement decided the request and the result of
+// every condition clause evaluated in doing so.
+func (e *Evaluator) EvaluateExplained(principal, action, resource, contextStr string) (EvaluationResult, error) {
+	trace := []ConditionResult{}
+	result, err := e.evaluate(context.Background(), principal, action, resource, contextStr, &trace)
+	result.Conditions = trace
+
+	return result, err
+}
+
+// GetMatchingPolicy is like Evaluate, but also returns the index and a copy of the statement which decided the
+// request. When the request is implicitly denied, the index is -1 and the statement is nil.
+func (e *Evaluator) GetMatchingPolicy(principal, action, resource, contextStr string) (int, *PolicyStatement, bool, error) {
+	result, err := e.evaluate(context.Background(), principal, action, resource, contextStr, nil)
+	if err != nil || result.PolicyIndex < 0 {
+		return -1, nil, false, err
+	}
+
+	stmt := e.policyStatements[result.PolicyIndex]
+	return result.PolicyIndex, &stmt, result.Permitted, nil
+}
+
+func (e *Evaluator) evaluate(ctx context.Context, principal, action, resource, contextStr string, trace *[]ConditionResult) (EvaluationResult, error) {
+	result := EvaluationResult{
+		PolicyIndex: -1,
+	}
+	if contextStr == "" {
+		contextStr = "{}"
+	}
+
+	policyStatements, err := e.parse()
 	if err != nil {
-		return false, err
+		return result, err
 	}
 
-	// evaluate forbids
-ForbidLoop:
-	for _, stmt := range *policyStatements {
-		if stmt.Effect == FORBID {
-			if !stmt.AnyPrincipal {
-				if stmt.Principal != "" {
-					if stmt.Principal != principal {
-						continue
-					}
-				} else if stmt.PrincipalParent != "" {
-					if stmt.PrincipalParent != principal {
-						if e.es == nil {
-							continue
-						} else {
-							descendants, err := e.es.GetEntityDescendents([]string{stmt.PrincipalParent})
-							if err != nil {
-								return false, err
-							}
-							if !containsEntity(descendants, principal) {
-								continue
-							}
-						}
-					}
-				} else {
-					return false, fmt.Errorf("unknown policy state")
-				}
+	// evaluate forbids, then permits
+	for _, effect := range []Token{FORBID, PERMIT} {
+		for i, stmt := range policyStatements {
+			if err := ctx.Err(); err != nil {
+				return result, err
 			}
-			if !stmt.AnyAction {
-				if stmt.Action != "" {
-					if !strings.Contains(stmt.Action, "::Action::\"") && !strings.HasPrefix(stmt.Action, "Action::\"") {
-						return false, fmt.Errorf("actions in scope must use Action:: namespace")
-					}
-					if stmt.Action != action {
-						continue
-					}
-				} else { // assumed ActionParent populated
-					if !contains(stmt.ActionParents, action) {
-						if e.es == nil {
-							continue
-						} else {
-							descendants, err := e.es.GetEntityDescendents(stmt.ActionParents)
-							if err != nil {
-								return false, err
-							}
-							for _, v := range descendants {
-								if !strings.Contains(v.Identifier, "::Action::\"") && !strings.HasPrefix(v.Identifier, "Action::\"") {
-									return false, fmt.Errorf("actions in scope must use Action:: namespace")
-								}
-							}
-							if !containsEntity(descendants, action) {
-								continue
-							}
-						}
-					}
-				}
+			if stmt.Effect != effect {
+				continue
 			}
-			if !stmt.AnyResource {
-				if stmt.Resource != "" {
-					if stmt.Resource != resource {
-						continue
-					}
-				} else if stmt.ResourceParent != "" {
-					if stmt.ResourceParent != resource {
-						if e.es == nil {
-							continue
-						} else {
-							descendants, err := e.es.GetEntityDescendents([]string{stmt.ResourceParent})
-							if err != nil {
-								return false, err
-							}
-							if !containsEntity(descendants, resource) {
-								continue
-							}
-						}
-					}
-				} else {
-					return false, fmt.Errorf("unknown policy state")
+
+			traceStart := 0
+			if trace != nil {
+				traceStart = len(*trace)
+			}
+			matched, err := e.evaluateStatement(ctx, stmt, principal, action, resource, contextStr, trace)
+			if trace != nil {
+				for j := traceStart; j < len(*trace); j++ {
+					(*trace)[j].PolicyIndex = i
 				}
 			}
+			if err != nil {
+				return result, err
+			}
+			if matched {
+				// explicit forbid or allow
+				result.Permitted = effect == PERMIT
+				result.Effect = effect
+				result.PolicyIndex = i
+				return result, nil
+			}
+		}
+	}
 
-			for _, stmtCondition := range stmt.Conditions {
-				condEvalResult, err := e.condEval(stmtCondition, principal, action, resource, context)
-				if err != nil {
-					return false, err
-				}
+	return result, nil // implicit deny
+}
 
-				if condEvalResult.Token != TRUE && condEvalResult.Token != FALSE {
-					return false, fmt.Errorf("condition return is not boolean")
-				}
+// EvaluateBatch evaluates each request, parsing the policy only once, and returns the results in the same order as
+// the requests. An error is only returned if the policy or entities cannot be parsed, otherwise errors are set on
+// the result of the request which caused them.
+func (e *Evaluator) EvaluateBatch(requests []EvaluationRequest) ([]EvaluationResult, error) {
+	if err := e.WarmUp(); err != nil {
+		return nil, err
+	}
+	if e.es != nil {
+		// load and index the entities up front, so that workers only read them
+		if _, _, err := e.es.GetEntity(""); err != nil {
+			return nil, err
+		}
+	}
 
-				if stmtCondition.Type == WHEN && condEvalResult.Token == FALSE {
-					continue ForbidLoop
-				} else if stmtCondition.Type == UNLESS && condEvalResult.Token == TRUE {
-					continue ForbidLoop
-				}
-			}
+	results := make([]EvaluationResult, len(requests))
+	evaluate := func(i int) {
+		results[i] = e.EvaluateRequest(requests[i])
+	}
 
-			return false, nil // explicit forbid
+	if e.Concurrency < 2 {
+		for i := range requests {
+			evaluate(i)
 		}
+		return results, nil
 	}
 
-	// evaluate permits
-PermitLoop:
-	for _, stmt := range *policyStatements {
-		if stmt.Effect == PERMIT {
-			if !stmt.AnyPrincipal {
-				if stmt.Principal != "" {
-					if stmt.Principal != principal {
-						continue
-					}
-				} else if stmt.PrincipalParent != "" {
-					if stmt.PrincipalParent != principal {
-						if e.es == nil {
-							continue
-						} else {
-							descendants, err := e.es.GetEntityDescendents([]string{stmt.PrincipalParent})
-							if err != nil {
-								return false, err
-							}
-							if !containsEntity(descendants, principal) {
-								continue
-							}
-						}
-					}
-				} else {
-					return false, fmt.Errorf("unknown policy state")
-				}
-			}
-			if !stmt.AnyAction {
-				if stmt.Action != "" {
-					if !strings.Contains(stmt.Action, "::Action::\"") && !strings.HasPrefix(stmt.Action, "Action::\"") {
-						return false, fmt.Errorf("actions in scope must use Action:: namespace")
-					}
-					if stmt.Action != action {
-						continue
-					}
-				} else { // assumed ActionParent populated
-					if !contains(stmt.ActionParents, action) {
-						if e.es == nil {
-							continue
-						} else {
-							descendants, err := e.es.GetEntityDescendents(stmt.ActionParents)
-							if err != nil {
-								return false, err
-							}
-							for _, v := range descendants {
-								if !strings.Contains(v.Identifier, "::Action::\"") && !strings.HasPrefix(v.Identifier, "Action::\"") {
-									return false, fmt.Errorf("actions in scope must use Action:: namespace")
-								}
-							}
-							if !containsEntity(descendants, action) {
-								continue
-							}
-						}
-					}
-				}
-			}
-			if !stmt.AnyResource {
-				if stmt.Resource != "" {
-					if stmt.Resource != resource {
-						continue
-					}
-				} else if stmt.ResourceParent != "" {
-					if stmt.ResourceParent != resource {
-						if e.es == nil {
-							continue
-						} else {
-							descendants, err := e.es.GetEntityDescendents([]string{stmt.ResourceParent})
-							if err != nil {
-								return false, err
-							}
-							if !containsEntity(descendants, resource) {
-								continue
-							}
-						}
-					}
-				} else {
-					return false, fmt.Errorf("unknown policy state")
-				}
+	var wg sync.WaitGroup
+	work := make(chan int)
+	for w := 0; w < e.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				evaluate(i)
 			}
+		}()
+	}
+	for i := range requests {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
 
-			for _, stmtCondition := range stmt.Conditions {
-				condEvalResult, err := e.condEval(stmtCondition, principal, action, resource, context)
-				if err != nil {
-					return false, err
-				}
+	return results, nil
+}
 
-				if condEvalResult.Token != TRUE && condEvalResult.Token != FALSE {
-					return false, fmt.Errorf("condition return is not boolean")
-				}
+// CountMatchingPolicies returns the number of permit and forbid statements whose scope and conditions match the request.
+// Unlike Evaluate, every statement is evaluated, which allows shadowed policies to be detected.
+func (e *Evaluator) CountMatchingPolicies(principal, action, resource, contextStr string) (permits int, forbids int, err error) {
+	matches, err := e.EvaluateAll(principal, action, resource, contextStr)
+	if err != nil {
+		return 0, 0, err
+	}
 
-				if stmtCondition.Type == WHEN && condEvalResult.Token == FALSE {
-					continue PermitLoop
-				} else if stmtCondition.Type == UNLESS && condEvalResult.Token == TRUE {
-					continue PermitLoop
-				}
-			}
+	for _, stmt := range matches {
+		if stmt.Effect == PERMIT {
+			permits++
+		} else if stmt.Effect == FORBID {
+			forbids++
+		}
+	}
 
-			return true, nil // explicit allow
+	return permits, forbids, nil
+}
+
+// EvaluateAll returns every permit and forbid statement whose scope and conditions match the request, in the order
+// they are declared in the policy. Unlike Evaluate, no precedence is applied between the statements.
+func (e *Evaluator) EvaluateAll(principal, action, resource, contextStr string) ([]PolicyStatement, error) {
+	if contextStr == "" {
+		contextStr = "{}"
+	}
+
+	policyStatements, err := e.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []PolicyStatement{}
+	for _, stmt := range policyStatements {
+		matched, err := e.evaluateStatement(context.Background(), stmt, principal, action, resource, contextStr, nil)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			matches = append(matches, stmt)
 		}
 	}
 
-	return false, nil // implicit deny
+	return matches, nil
 }
 
-func (e *Evaluator) wrapIfThenElse(sequenceItemList []SequenceItem) []SequenceItem {
-	i := 0
-	for i < len(sequenceItemList) {
-		if sequenceItemList[i].Token == IF {
-			// splice ( after
-			sequenceItemList = append(sequenceItemList[:i+2], sequenceItemList[i+1:]...)
-			sequenceItemList[i+1] = SequenceItem{Token: LEFT_PAREN, Literal: "(", Normalized: "("}
-			i++
-		} else if sequenceItemList[i].Token == THEN {
-			// splice ) before
-			sequenceItemList = append(sequenceItemList[:i+1], sequenceItemList[i:]...)
-			sequenceItemList[i] = SequenceItem{Token: RIGHT_PAREN, Literal: ")", Normalized: ")"}
-			// splice ( after
-			sequenceItemList = append(sequenceItemList[:i+3], sequenceItemList[i+2:]...)
-			sequenceItemList[i+2] = SequenceItem{Token: LEFT_PAREN, Literal: "(", Normalized: "("}
-			i += 2
-		} else if sequenceItemList[i].Token == ELSE {
-			// splice ) before
-			sequenceItemList = append(sequenceItemList[:i+1], sequenceItemList[i:]...)
-			sequenceItemList[i] = SequenceItem{Token: RIGHT_PAREN, Literal: ")", Normalized: ")"}
-			i++
+// BindSlots returns a new evaluator with the ?principal and ?resource slots of any policy templates replaced by
+// the entities provided in slots, keyed by slot name (e.g. "?principal"). The entity store is shared with the
+// returned evaluator.
+func (e *Evaluator) BindSlots(slots map[string]string) (*Evaluator, error) {
+	for slot, entity := range slots {
+		if slot != PrincipalSlot && slot != ResourceSlot {
+			return nil, &EvalError{Msg: fmt.Sprintf("unknown template slot %q", slot)}
+		}
+		if entityType(entity) == "" {
+			return nil, &EntityError{Identifier: entity, Msg: fmt.Sprintf("invalid entity %q for template slot %s", entity, slot)}
 		}
-		i++
 	}
 
-	return sequenceItemList
+	policyStatements, err := e.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	boundStatements := PolicySet{}
+	for _, stmt := range policyStatements {
+		for _, field := range []*string{&stmt.Principal, &stmt.PrincipalParent, &stmt.Resource, &stmt.ResourceParent} {
+			if *field != PrincipalSlot && *field != ResourceSlot {
+				continue
+			}
+			entity, ok := slots[*field]
+			if !ok {
+				return nil, &EvalError{Msg: fmt.Sprintf("no value provided for template slot %s", *field)}
+			}
+			*field = entity
+		}
+		boundStatements = append(boundStatements, stmt)
+	}
+
+	return &Evaluator{
+		p:                    e.p,
+		es:                   e.es,
+		policyStatements:     boundStatements,
+		parsed:               true,
+		unrereadable:         true,
+		opts:                 e.opts,
+		constructors:         maps.Clone(e.constructors),
+		methods:              maps.Clone(e.methods),
+		fallbackMethods:      maps.Clone(e.fallbackMethods),
+		AllowShortCircuiting: e.AllowShortCircuiting,
+		Concurrency:          e.Concurrency,
+		LikeComplexityLimit:  e.LikeComplexityLimit,
+		DecimalPrecision:     e.DecimalPrecision,
+	}, nil
 }
 
-func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, context string) (SequenceItem, error) {
-	var outputQueue []SequenceItem
-	var operatorStack []SequenceItem
+// parse returns the policy statements of the evaluator, parsing and caching the policy if they have not already
+// been set.
+func (e *Evaluator) parse() (PolicySet, error) {
+	if err := e.WarmUp(); err != nil {
+		return nil, err
+	}
 
-	// wrap the tokens between if -> then & then -> else to ensure embedded if-then-else may work
-	cc.Sequence = e.wrapIfThenElse(cc.Sequence)
+	return e.policyStatements, nil
+}
 
-	// restructure to rpn using shunting yard, and set normalized if not set
-	for _, s := range cc.Sequence {
-		switch s.Token {
-		case TRUE, FALSE, LONG, DBLQUOTESTR, ENTITY, ATTRIBUTE:
-			outputQueue = append(outputQueue, s)
-		case PRINCIPAL:
-			s.Token = ENTITY
-			s.Normalized = principal
-			outputQueue = append(outputQueue, s)
-		case ACTION:
+// evaluateStatement returns whether the scope and all condition clauses of a policy statement match the request.
+// If trace is not nil, the result of each evaluated condition clause is appended to it.
+func (e *Evaluator) evaluateStatement(ctx context.Context, stmt PolicyStatement, principal, action, resource, context string, trace *[]ConditionResult) (bool, error) {
+	if stmt.IsTemplate() {
+		return false, &EvalError{Msg: "policy template slots must be bound before evaluation"}
+	}
+	if !stmt.AnyPrincipal {
+		if stmt.Principal != "" {
+			if stmt.Principal != principal {
+				return false, nil
+			}
+		} else if stmt.PrincipalParent != "" {
+			if stmt.PrincipalParent != principal {
+				if e.es == nil {
+					return false, nil
+				}
+				found, err := e.es.hasAncestor(ctx, principal, []string{stmt.PrincipalParent})
+				if err != nil {
+					return false, err
+				}
+				if !found {
+					return false, nil
+				}
+			}
+		} else if stmt.PrincipalType != "" {
+			if entityType(principal) != stmt.PrincipalType {
+				return false, nil
+			}
+		} else {
+			return false, &EvalError{Msg: "unknown policy state"}
+		}
+	}
+	if !stmt.AnyAction {
+		if stmt.Action != "" {
+			if !strings.Contains(stmt.Action, "::Action::\"") && !strings.HasPrefix(stmt.Action, "Action::\"") {
+				return false, &EvalError{Msg: "actions in scope must use Action:: namespace"}
+			}
+			if stmt.Action != action {
+				return false, nil
+			}
+		} else if stmt.ActionType != "" {
+			if entityType(action) != stmt.ActionType {
+				return false, nil
+			}
+		} else { // assumed ActionParent populated
+			if !contains(stmt.ActionParents, action) {
+				if e.es == nil {
+					return false, nil
+				}
+				descendants, err := e.es.GetEntityDescendentsWithContext(ctx, stmt.ActionParents)
+				if err != nil {
+					return false, err
+				}
+				for _, v := range descendants {
+					if !strings.Contains(v.Identifier, "::Action::\"") && !strings.HasPrefix(v.Identifier, "Action::\"") {
+						return false, &EvalError{Msg: "actions in scope must use Action:: namespace"}
+					}
+				}
+				if !containsEntity(descendants, action) {
+					return false, nil
+				}
+			}
+		}
+	}
+	if !stmt.AnyResource {
+		if stmt.Resource != "" {
+			if stmt.Resource != resource {
+				return false, nil
+			}
+		} else if stmt.ResourceParent != "" {
+			if stmt.ResourceParent != resource {
+				if e.es == nil {
+					return false, nil
+				}
+				found, err := e.es.hasAncestor(ctx, resource, []string{stmt.ResourceParent})
+				if err != nil {
+					return false, err
+				}
+				if !found {
+					return false, nil
+				}
+			}
+		} else if stmt.ResourceType != "" {
+			if entityType(resource) != stmt.ResourceType {
+				return false, nil
+			}
+		} else {
+			return false, &EvalError{Msg: "unknown policy state"}
+		}
+	}
+
+	for _, stmtCondition := range stmt.Conditions {
+		condEvalResult, err := e.condEval(ctx, stmtCondition, principal, action, resource, context, 0)
+		if err == nil && condEvalResult.Token != TRUE && condEvalResult.Token != FALSE {
+			err = &EvalError{Msg: "condition return is not boolean"}
+		}
+		var evalErr *EvalError
+		if errors.As(err, &evalErr) && evalErr.Expr == "" {
+			evalErr.Expr = serializeSequence(stmtCondition.Sequence)
+		}
+		if trace != nil {
+			*trace = append(*trace, ConditionResult{
+				ClauseType: stmtCondition.Type,
+				Outcome:    err == nil && condEvalResult.Token == TRUE,
+				Error:      err,
+			})
+		}
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return false, ctxErr
+			}
+			return false, err
+		}
+
+		if stmtCondition.Type == WHEN && condEvalResult.Token == FALSE {
+			return false, nil
+		} else if stmtCondition.Type == UNLESS && condEvalResult.Token == TRUE {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (e *Evaluator) wrapIfThenElse(sequenceItemList []SequenceItem) []SequenceItem {
+	// splice into a copy, as the sequence may belong to a retained policy statement
+	sequenceItemList = append([]SequenceItem(nil), sequenceItemList...)
+
+	i := 0
+	for i < len(sequenceItemList) {
+		if sequenceItemList[i].Token == IF {
+			// splice ( after
+			sequenceItemList = append(sequenceItemList[:i+2], sequenceItemList[i+1:]...)
+			sequenceItemList[i+1] = SequenceItem{Token: LEFT_PAREN, Literal: "(", Normalized: "("}
+			i++
+		} else if sequenceItemList[i].Token == THEN {
+			// splice ) before
+			sequenceItemList = append(sequenceItemList[:i+1], sequenceItemList[i:]...)
+			sequenceItemList[i] = SequenceItem{Token: RIGHT_PAREN, Literal: ")", Normalized: ")"}
+			// splice ( after
+			sequenceItemList = append(sequenceItemList[:i+3], sequenceItemList[i+2:]...)
+			sequenceItemList[i+2] = SequenceItem{Token: LEFT_PAREN, Literal: "(", Normalized: "("}
+			i += 2
+		} else if sequenceItemList[i].Token == ELSE {
+			// splice ) before
+			sequenceItemList = append(sequenceItemList[:i+1], sequenceItemList[i:]...)
+			sequenceItemList[i] = SequenceItem{Token: RIGHT_PAREN, Literal: ")", Normalized: ")"}
+			i++
+		}
+		i++
+	}
+
+	return sequenceItemList
+}
+
+// evalSetLiteral evaluates each comma-separated element of a set literal, given the items between its square
+// brackets, and returns the resulting set.
+func (e *Evaluator) evalSetLiteral(ctx context.Context, cc ConditionClause, elements []SequenceItem, principal, action, resource, context string, depth int) SequenceItem {
+	set := []string{}
+	if len(elements) > 0 {
+		start, nesting := 0, 0
+		for i := 0; i <= len(elements); i++ {
+			if i < len(elements) {
+				switch elements[i].Token {
+				case LEFT_PAREN, LEFT_SQB, LEFT_BRACE:
+					nesting++
+				case RIGHT_PAREN, RIGHT_SQB, RIGHT_BRACE:
+					nesting--
+				}
+				if elements[i].Token != COMMA || nesting != 0 {
+					continue
+				}
+			}
+
+			if i == start {
+				return SequenceItem{
+					Token:      ERROR,
+					Literal:    "error whilst processing set",
+					Normalized: "error whilst processing set",
+				}
+			}
+			item, err := e.condEval(ctx, ConditionClause{Type: cc.Type, Sequence: elements[start:i], Bindings: cc.Bindings}, principal, action, resource, context, depth+1)
+			if err != nil {
+				return errorSequenceItem(err)
+			}
+			set = append(set, item.Normalized)
+			start = i + 1
+		}
+	}
+
+	b, err := json.Marshal(set)
+	if err != nil {
+		return SequenceItem{
+			Token:      ERROR,
+			Literal:    "error whilst processing set",
+			Normalized: "error whilst processing set",
+		}
+	}
+
+	return SequenceItem{
+		Token:      SET,
+		Literal:    string(b),
+		Normalized: string(b),
+	}
+}
+
+// condEval evaluates a condition clause. The depth is the number of recursive condEval calls made to reach this call.
+func (e *Evaluator) condEval(ctx context.Context, cc ConditionClause, principal, action, resource, context string, depth int) (SequenceItem, error) {
+	if depth > e.opts.MaxRecursionDepth {
+		return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("maximum recursion depth of %d exceeded", e.opts.MaxRecursionDepth)}
+	}
+	if err := ctx.Err(); err != nil {
+		return SequenceItem{}, err
+	}
+
+	var outputQueue []SequenceItem
+	var operatorStack []SequenceItem
+
+	// wrap the tokens between if -> then & then -> else to ensure embedded if-then-else may work
+	cc.Sequence = e.wrapIfThenElse(cc.Sequence)
+
+	// restructure to rpn using shunting yard, and set normalized if not set
+	setEnd := -1
+	for i, s := range cc.Sequence {
+		if i <= setEnd {
+			continue // already evaluated as part of a set literal
+		}
+
+		switch s.Token {
+		case TRUE, FALSE, LONG, DBLQUOTESTR, ENTITY, ATTRIBUTE, IDENT, LETIDENT, RECORD, SET:
+			outputQueue = append(outputQueue, s)
+		case PRINCIPAL:
+			s.Token = ENTITY
+			s.Normalized = principal
+			outputQueue = append(outputQueue, s)
+		case ACTION:
 			s.Token = ENTITY
 			s.Normalized = action
 			outputQueue = append(outputQueue, s)
@@ -339,27 +777,47 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 			s.Normalized = context
 			outputQueue = append(outputQueue, s)
 		case LEFT_SQB:
-			outputQueue = append(outputQueue, s)
+			setEnd = matchingBracket(cc.Sequence, i)
+			if setEnd < 0 {
+				return SequenceItem{}, &EvalError{Msg: "mismatched square bracket"}
+			}
+			outputQueue = append(outputQueue, e.evalSetLiteral(ctx, cc, cc.Sequence[i+1:setEnd], principal, action, resource, context, depth))
 		case LEFT_BRACE:
+			// the brace marks the start of the record in the output, and bounds the operators of its values
 			outputQueue = append(outputQueue, s)
-		case COMMA:
-			outputQueue = append(outputQueue, s)
+			operatorStack = append(operatorStack, s)
 		case RECORDKEY:
 			outputQueue = append(outputQueue, s)
-		case COLON:
+		case COMMA, COLON:
+			for len(operatorStack) > 0 && operatorStack[len(operatorStack)-1].Token != LEFT_PAREN && operatorStack[len(operatorStack)-1].Token != LEFT_BRACE {
+				outputQueue = append(outputQueue, operatorStack[len(operatorStack)-1])
+				operatorStack = operatorStack[:len(operatorStack)-1]
+			}
 			outputQueue = append(outputQueue, s)
-		case RIGHT_SQB:
-			operatorStack = append(operatorStack, s)
 		case RIGHT_BRACE:
+			for {
+				if len(operatorStack) < 1 {
+					return SequenceItem{}, &EvalError{Msg: "mismatched brace"}
+				}
+				pop := operatorStack[len(operatorStack)-1]
+				operatorStack = operatorStack[:len(operatorStack)-1]
+
+				if pop.Token != LEFT_BRACE {
+					outputQueue = append(outputQueue, pop)
+				} else {
+					break
+				}
+			}
 			operatorStack = append(operatorStack, s)
 		case LEFT_PAREN:
 			operatorStack = append(operatorStack, s)
 		case FUNCTION:
+			s.arity = functionArity(cc.Sequence, i)
 			operatorStack = append(operatorStack, s)
 		case RIGHT_PAREN:
 			for {
 				if len(operatorStack) < 1 {
-					return SequenceItem{}, fmt.Errorf("mismatched parenthesis")
+					return SequenceItem{}, &EvalError{Msg: "mismatched parenthesis"}
 				}
 				pop := operatorStack[len(operatorStack)-1]
 				operatorStack = operatorStack[:len(operatorStack)-1]
@@ -370,7 +828,7 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 					break
 				}
 			}
-		case EQUALITY, INEQUALITY, AND, OR, LT, LTE, GT, GTE, PLUS, DASH, MULTIPLIER, IN, HAS, LIKE, PERIOD, EXCLAMATION, IF, THEN, ELSE:
+		case EQUALITY, INEQUALITY, AND, OR, LT, LTE, GT, GTE, PLUS, DASH, MULTIPLIER, SLASH, PERCENT, IN, IS, HAS, LIKE, PERIOD, EXCLAMATION, UNARYMINUS, IF, THEN, ELSE:
 			for len(operatorStack) > 0 && OP_PRECEDENCE[operatorStack[len(operatorStack)-1].Token] != 0 && (OP_PRECEDENCE[operatorStack[len(operatorStack)-1].Token] > OP_PRECEDENCE[s.Token] || (OP_PRECEDENCE[operatorStack[len(operatorStack)-1].Token] == OP_PRECEDENCE[s.Token] && LEFT_ASSOCIATIVE[s.Token])) {
 				pop := operatorStack[len(operatorStack)-1]
 				operatorStack = operatorStack[:len(operatorStack)-1]
@@ -378,7 +836,7 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 			}
 			operatorStack = append(operatorStack, s)
 		default:
-			return SequenceItem{}, fmt.Errorf("unknown token during restructure: (%v)", s.Token)
+			return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("unknown token during restructure: (%v)", s.Token)}
 		}
 	}
 
@@ -386,28 +844,74 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 		pop := operatorStack[len(operatorStack)-1]
 		operatorStack = operatorStack[:len(operatorStack)-1]
 		if pop.Token == LEFT_PAREN {
-			return SequenceItem{}, fmt.Errorf("mismatched parenthesis")
+			return SequenceItem{}, &EvalError{Msg: "mismatched parenthesis"}
+		}
+		if pop.Token == LEFT_BRACE {
+			return SequenceItem{}, &EvalError{Msg: "mismatched brace"}
 		}
 		outputQueue = append(outputQueue, pop)
 	}
 
-	fmt.Println("--")
-	fmt.Println(cc.ToString())
-	fmt.Println(outputQueue)
-
 	var evalStack []SequenceItem
 	var lhs SequenceItem
 	var rhs SequenceItem
-	fmt.Println("###")
-	fmt.Println(outputQueue)
 	for _, s := range outputQueue {
-		fmt.Println(evalStack)
+		if len(evalStack) < operandCount(s.Token) {
+			// an operator is missing an operand, e.g. true ||
+			return SequenceItem{}, &EvalError{Msg: "invalid stack state"}
+		}
 
 		switch s.Token {
 		case COMMA:
-		case TRUE, FALSE, LONG, DBLQUOTESTR, ENTITY, ATTRIBUTE, CONTEXT, LEFT_SQB, LEFT_BRACE, COLON, RECORDKEY:
+		case TRUE, FALSE, LONG, DBLQUOTESTR, ENTITY, ATTRIBUTE, IDENT, CONTEXT, LEFT_BRACE, COLON, RECORDKEY, RECORD, SET, ERROR:
 			evalStack = append(evalStack, s)
-		case EXCLAMATION: // TODO: limit to 4x sequentially, also negation unary
+		case LETIDENT:
+			// let bindings are evaluated lazily, only when referenced
+			i, err := strconv.Atoi(s.Normalized)
+			if err != nil || i < 0 || i >= len(cc.Bindings) {
+				return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("unknown let binding %q", s.Literal)}
+			}
+			result, err := e.condEval(ctx, ConditionClause{Type: cc.Type, Sequence: cc.Bindings[i].Sequence, Bindings: cc.Bindings[:i]}, principal, action, resource, context, depth+1)
+			if err != nil {
+				return SequenceItem{}, err
+			}
+			evalStack = append(evalStack, result)
+		case UNARYMINUS:
+			rhs = evalStack[len(evalStack)-1]
+			evalStack = evalStack[:len(evalStack)-1]
+
+			if bubbleErrors(&evalStack, rhs) {
+				continue
+			}
+
+			if rhs.Token != LONG {
+				evalStack = append(evalStack, SequenceItem{
+					Token:      ERROR,
+					Literal:    "attempted to negate non-long",
+					Normalized: "attempted to negate non-long",
+				})
+				continue
+			}
+			rhsL, err := strconv.ParseInt(rhs.Normalized, 10, 64)
+			if err != nil {
+				evalStack = append(evalStack, errorSequenceItem(err))
+				continue
+			}
+			result, ok := subInt64(0, rhsL)
+			if !ok {
+				evalStack = append(evalStack, SequenceItem{
+					Token:      ERROR,
+					Literal:    fmt.Sprintf("integer overflow: -(%d)", rhsL),
+					Normalized: fmt.Sprintf("integer overflow: -(%d)", rhsL),
+				})
+				continue
+			}
+			evalStack = append(evalStack, SequenceItem{
+				Token:      LONG,
+				Literal:    strconv.FormatInt(result, 10),
+				Normalized: strconv.FormatInt(result, 10),
+			})
+		case EXCLAMATION:
 			rhs = evalStack[len(evalStack)-1]
 			evalStack = evalStack[:len(evalStack)-1]
 
@@ -486,7 +990,7 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 						Normalized: "false",
 					})
 				} else {
-					return SequenceItem{}, fmt.Errorf("invalid use of if-then-else block, got if %v, then-else %v", ifResult.Token, thenElseResult.Token)
+					return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("invalid use of if-then-else block, got if %v, then-else %v", ifResult.Token, thenElseResult.Token)}
 				}
 			}
 		case THEN:
@@ -543,6 +1047,11 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 					Normalized: thenResult.Normalized,
 				})
 			} else {
+				// neither branch resolved to a boolean, so surface the underlying errors where present
+				if bubbleErrors(&evalStack, thenResult, elseResult) {
+					continue
+				}
+
 				evalStack = append(evalStack, SequenceItem{
 					Token:      ERROR,
 					Literal:    fmt.Sprintf("invalid use of if-then-else block, got then %v, else %v", thenResult.Token, elseResult.Token),
@@ -579,79 +1088,49 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 				continue
 			}
 		case FUNCTION:
-			rhs = evalStack[len(evalStack)-1]
-			lit := rhs.Normalized
+			if s.arity > 0 {
+				// a method, applied to its receiver by the following period
+				evalStack = append(evalStack, s)
+				continue
+			}
+			if s.Normalized == "now" {
+				evalStack = append(evalStack, datetimeSequenceItem(e.opts.Clock()))
+				continue
+			}
 
-			if s.Normalized == "ip" {
-				evalStack = evalStack[:len(evalStack)-1]
+			if len(evalStack) < 1 {
+				return SequenceItem{}, &EvalError{Msg: "invalid stack state"}
+			}
+			rhs = evalStack[len(evalStack)-1]
+			evalStack = evalStack[:len(evalStack)-1]
 
-				if bubbleErrors(&evalStack, rhs) {
-					continue
-				}
+			if bubbleErrors(&evalStack, rhs) {
+				continue
+			}
 
-				normalized := lit
-				if !strings.Contains(lit, "/") {
-					if strings.Count(lit, ":") >= 2 {
-						normalized += "/128"
-					} else {
-						normalized += "/32"
-					}
-				}
-				_, ipNet, err := net.ParseCIDR(normalized)
-				if err != nil {
-					evalStack = append(evalStack, SequenceItem{
-						Token:      ERROR,
-						Literal:    "invalid ip",
-						Normalized: "invalid ip",
-					})
-					continue
-				}
+			constructor, ok := e.constructors[s.Normalized]
+			if !ok {
 				evalStack = append(evalStack, SequenceItem{
-					Token:      IP,
-					Literal:    lit,
-					Normalized: ipNet.String(),
+					Token:      ERROR,
+					Literal:    fmt.Sprintf("unknown function: %s", s.Literal),
+					Normalized: fmt.Sprintf("unknown function: %s", s.Literal),
 				})
-			} else if s.Normalized == "decimal" {
-				evalStack = evalStack[:len(evalStack)-1]
-
-				if bubbleErrors(&evalStack, rhs) {
-					continue
-				}
+				continue
+			}
 
-				i := strings.IndexByte(lit, '.')
-				if i > -1 {
-					if (len(lit) - i - 1) > 4 {
-						evalStack = append(evalStack, SequenceItem{
-							Token:      ERROR,
-							Literal:    "too much precision in decimal",
-							Normalized: "too much precision in decimal",
-						})
-						continue
-					}
-				}
-				f, err := strconv.ParseFloat(lit, 64)
-				if err != nil {
-					evalStack = append(evalStack, SequenceItem{
-						Token:      ERROR,
-						Literal:    "error parsing decimal",
-						Normalized: "error parsing decimal",
-					})
-					continue
-				}
-				evalStack = append(evalStack, SequenceItem{
-					Token:      DECIMAL,
-					Literal:    lit,
-					Normalized: strconv.FormatFloat(f, 'f', 4, 64),
-				})
-			} else {
-				evalStack = append(evalStack, s)
+			item, err := constructor(e, rhs)
+			if err != nil {
+				evalStack = append(evalStack, errorSequenceItem(err))
+				continue
 			}
+			evalStack = append(evalStack, item)
 		case PERIOD:
 			rhs = evalStack[len(evalStack)-1]
 			lhs = evalStack[len(evalStack)-2]
 			evalStack = evalStack[:len(evalStack)-2]
 
-			if bubbleErrors(&evalStack, lhs, rhs) {
+			// a method's arguments are bubbled together with its receiver below
+			if rhs.Token != FUNCTION && bubbleErrors(&evalStack, lhs, rhs) {
 				continue
 			}
 
@@ -660,559 +1139,63 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 			if lhs.Token == CONTEXT && rhs.Token == ATTRIBUTE {
 				item, err := e.getAttributeAttributeSequenceItem(lhs.Normalized, rhs.Normalized)
 				if err != nil {
-					evalStack = append(evalStack, SequenceItem{
-						Token:      ERROR,
-						Literal:    err.Error(),
-						Normalized: err.Error(),
-					})
-					continue
-				}
-				evalStack = append(evalStack, item)
-			} else if lhs.Token == ENTITY && rhs.Token == ATTRIBUTE {
-				if e.es == nil {
-					evalStack = append(evalStack, SequenceItem{
-						Token:      ERROR,
-						Literal:    fmt.Sprintf("invalid attribute access (no entities available): (%v)", s.Token),
-						Normalized: fmt.Sprintf("invalid attribute access (no entities available): (%v)", s.Token),
-					})
-					continue
-				} else {
-					item, err := e.getEntityAttributeSequenceItem(lhs.Normalized, rhs.Normalized)
-					if err != nil {
-						evalStack = append(evalStack, SequenceItem{
-							Token:      ERROR,
-							Literal:    err.Error(),
-							Normalized: err.Error(),
-						})
-						continue
-					}
-					evalStack = append(evalStack, item)
-				}
-			} else if lhs.Token == ATTRIBUTE && rhs.Token == ATTRIBUTE {
-				item, err := e.getAttributeAttributeSequenceItem(lhs.Normalized, rhs.Normalized)
-				if err != nil {
-					evalStack = append(evalStack, SequenceItem{
-						Token:      ERROR,
-						Literal:    err.Error(),
-						Normalized: err.Error(),
-					})
+					evalStack = append(evalStack, errorSequenceItem(err))
 					continue
 				}
-				evalStack = append(evalStack, item)
-			} else if lhs.Token == RECORD && rhs.Token == ATTRIBUTE {
-				item, err := e.getRecordAttributeSequenceItem(lhs.RecordKeyValuePairs, rhs.Normalized)
-				if err != nil {
-					evalStack = append(evalStack, SequenceItem{
-						Token:      ERROR,
-						Literal:    err.Error(),
-						Normalized: err.Error(),
-					})
-					continue
-				}
-				evalStack = append(evalStack, item)
-			} else if rhs.Token == FUNCTION {
-				if rhs.Normalized == "contains" {
-					actualLhs := evalStack[len(evalStack)-1]
-					evalStack = evalStack[:len(evalStack)-1]
-
-					if bubbleErrors(&evalStack, actualLhs) {
-						continue
-					}
-
-					if actualLhs.Token != SET {
-						evalStack = append(evalStack, SequenceItem{
-							Token:      ERROR,
-							Literal:    "unexpected use of contains function",
-							Normalized: "unexpected use of contains function",
-						})
-						continue
-					}
-					var actualLhsSet []interface{}
-					err := json.Unmarshal([]byte(actualLhs.Normalized), &actualLhsSet)
-					if err != nil {
-						evalStack = append(evalStack, SequenceItem{
-							Token:      ERROR,
-							Literal:    err.Error(),
-							Normalized: err.Error(),
-						})
-						continue
-					}
-					item := SequenceItem{
-						Token:      FALSE,
-						Literal:    "false",
-						Normalized: "false",
-					}
-					for _, setItem := range actualLhsSet {
-						if lhs.Normalized == setItem {
-							item = SequenceItem{
-								Token:      TRUE,
-								Literal:    "true",
-								Normalized: "true",
-							}
-							break
-						}
-					}
-					evalStack = append(evalStack, item)
-				} else if lhs.Token == SET {
-					if rhs.Normalized == "containsAll" {
-						actualLhs := evalStack[len(evalStack)-1]
-						evalStack = evalStack[:len(evalStack)-1]
-
-						if bubbleErrors(&evalStack, actualLhs) {
-							continue
-						}
-
-						if actualLhs.Token != SET {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      ERROR,
-								Literal:    "unexpected use of containsAll function",
-								Normalized: "unexpected use of containsAll function",
-							})
-							continue
-						}
-						var actualLhsSet []interface{}
-						err := json.Unmarshal([]byte(actualLhs.Normalized), &actualLhsSet)
-						if err != nil {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      ERROR,
-								Literal:    err.Error(),
-								Normalized: err.Error(),
-							})
-							continue
-						}
-						var actualRhsSet []interface{}
-						err = json.Unmarshal([]byte(lhs.Normalized), &actualRhsSet)
-						if err != nil {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      ERROR,
-								Literal:    err.Error(),
-								Normalized: err.Error(),
-							})
-							continue
-						}
-						item := SequenceItem{
-							Token:      TRUE,
-							Literal:    "true",
-							Normalized: "true",
-						}
-						for _, rhsSetItem := range actualRhsSet {
-							found := false
-							for _, lhsSetItem := range actualLhsSet {
-								if rhsSetItem == lhsSetItem {
-									found = true
-									break
-								}
-							}
-							if !found {
-								item = SequenceItem{
-									Token:      FALSE,
-									Literal:    "false",
-									Normalized: "false",
-								}
-								break
-							}
-						}
-						evalStack = append(evalStack, item)
-					} else if rhs.Normalized == "containsAny" {
-						actualLhs := evalStack[len(evalStack)-1]
-						evalStack = evalStack[:len(evalStack)-1]
-
-						if bubbleErrors(&evalStack, actualLhs) {
-							continue
-						}
-
-						if actualLhs.Token != SET {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      ERROR,
-								Literal:    "unexpected use of containsAny function",
-								Normalized: "unexpected use of containsAny function",
-							})
-							continue
-						}
-						var actualLhsSet []interface{}
-						err := json.Unmarshal([]byte(actualLhs.Normalized), &actualLhsSet)
-						if err != nil {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      ERROR,
-								Literal:    err.Error(),
-								Normalized: err.Error(),
-							})
-							continue
-						}
-						var actualRhsSet []interface{}
-						err = json.Unmarshal([]byte(lhs.Normalized), &actualRhsSet)
-						if err != nil {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      ERROR,
-								Literal:    err.Error(),
-								Normalized: err.Error(),
-							})
-							continue
-						}
-						item := SequenceItem{
-							Token:      FALSE,
-							Literal:    "false",
-							Normalized: "false",
-						}
-					ActualRhsSetLoop:
-						for _, rhsSetItem := range actualRhsSet {
-							for _, lhsSetItem := range actualLhsSet {
-								if rhsSetItem == lhsSetItem {
-									item = SequenceItem{
-										Token:      TRUE,
-										Literal:    "true",
-										Normalized: "true",
-									}
-									break ActualRhsSetLoop
-								}
-							}
-						}
-						evalStack = append(evalStack, item)
-					} else {
-						evalStack = append(evalStack, SequenceItem{
-							Token:      ERROR,
-							Literal:    fmt.Sprintf("unknown function: %s", rhs.Literal),
-							Normalized: fmt.Sprintf("unknown function: %s", rhs.Literal),
-						})
-						continue
-					}
-				} else if lhs.Token == IP {
-					if rhs.Normalized == "isIpv4" {
-						if strings.Count(lhs.Normalized, ":") < 2 {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      TRUE,
-								Literal:    "true",
-								Normalized: "true",
-							})
-						} else {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      FALSE,
-								Literal:    "false",
-								Normalized: "false",
-							})
-						}
-					} else if rhs.Normalized == "isIpv6" {
-						if strings.Count(lhs.Normalized, ":") >= 2 {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      TRUE,
-								Literal:    "true",
-								Normalized: "true",
-							})
-						} else {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      FALSE,
-								Literal:    "false",
-								Normalized: "false",
-							})
-						}
-					} else if rhs.Normalized == "isInRange" {
-						insideRange := evalStack[len(evalStack)-1]
-						evalStack = evalStack[:len(evalStack)-1]
-
-						if bubbleErrors(&evalStack, insideRange) {
-							continue
-						}
-
-						_, ipNet, err := net.ParseCIDR(lhs.Normalized)
-						if err != nil {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      ERROR,
-								Literal:    "invalid IP",
-								Normalized: "invalid IP",
-							})
-							continue
-						}
-						_, insideIpNet, err := net.ParseCIDR(insideRange.Normalized)
-						if err != nil {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      ERROR,
-								Literal:    "invalid IP",
-								Normalized: "invalid IP",
-							})
-							continue
-						}
-
-						for i := range insideIpNet.IP {
-							insideIpNet.IP[i] &= insideIpNet.Mask[i]
-						}
-						firstIPInCIDR := insideIpNet.IP
-
-						for i := range insideIpNet.IP {
-							insideIpNet.IP[i] |= ^insideIpNet.Mask[i]
-						}
-						lastIPInCIDR := insideIpNet.IP
-
-						if ipNet.Contains(firstIPInCIDR) && ipNet.Contains(lastIPInCIDR) {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      TRUE,
-								Literal:    "true",
-								Normalized: "true",
-							})
-						} else {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      FALSE,
-								Literal:    "false",
-								Normalized: "false",
-							})
-						}
-					} else if rhs.Normalized == "isLoopback" {
-						_, ipNet, err := net.ParseCIDR(lhs.Normalized)
-						if err != nil {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      ERROR,
-								Literal:    "invalid IP",
-								Normalized: "invalid IP",
-							})
-							continue
-						}
-
-						for i := range ipNet.IP {
-							ipNet.IP[i] &= ipNet.Mask[i]
-						}
-						firstIPInCIDR := ipNet.IP
-
-						for i := range ipNet.IP {
-							ipNet.IP[i] |= ^ipNet.Mask[i]
-						}
-						lastIPInCIDR := ipNet.IP
-
-						if firstIPInCIDR.IsLoopback() && lastIPInCIDR.IsLoopback() {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      TRUE,
-								Literal:    "true",
-								Normalized: "true",
-							})
-						} else {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      FALSE,
-								Literal:    "false",
-								Normalized: "false",
-							})
-						}
-					} else if rhs.Normalized == "isMulticast" {
-						_, ipNet, err := net.ParseCIDR(lhs.Normalized)
-						if err != nil {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      ERROR,
-								Literal:    "invalid IP",
-								Normalized: "invalid IP",
-							})
-							continue
-						}
-
-						for i := range ipNet.IP {
-							ipNet.IP[i] &= ipNet.Mask[i]
-						}
-						firstIPInCIDR := ipNet.IP
-
-						for i := range ipNet.IP {
-							ipNet.IP[i] |= ^ipNet.Mask[i]
-						}
-						lastIPInCIDR := ipNet.IP
-
-						if firstIPInCIDR.IsMulticast() && lastIPInCIDR.IsMulticast() {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      TRUE,
-								Literal:    "true",
-								Normalized: "true",
-							})
-						} else {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      FALSE,
-								Literal:    "false",
-								Normalized: "false",
-							})
-						}
-					} else {
-						evalStack = append(evalStack, SequenceItem{
-							Token:      ERROR,
-							Literal:    fmt.Sprintf("unknown IP function: %s", rhs.Literal),
-							Normalized: fmt.Sprintf("unknown IP function: %s", rhs.Literal),
-						})
-						continue
-					}
-				} else if lhs.Token == DECIMAL {
-					if rhs.Normalized == "lessThan" {
-						actualLhs := evalStack[len(evalStack)-1]
-						evalStack = evalStack[:len(evalStack)-1]
-
-						if bubbleErrors(&evalStack, actualLhs) {
-							continue
-						}
-
-						lhsD, err := strconv.ParseFloat(actualLhs.Normalized, 64)
-						if err != nil {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      ERROR,
-								Literal:    "error parsing decimal",
-								Normalized: "error parsing decimal",
-							})
-							continue
-						}
-						rhsD, err := strconv.ParseFloat(lhs.Normalized, 64)
-						if err != nil {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      ERROR,
-								Literal:    "error parsing decimal",
-								Normalized: "error parsing decimal",
-							})
-							continue
-						}
-
-						if lhsD < rhsD {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      TRUE,
-								Literal:    "true",
-								Normalized: "true",
-							})
-						} else {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      FALSE,
-								Literal:    "false",
-								Normalized: "false",
-							})
-						}
-					} else if rhs.Normalized == "lessThanOrEqual" {
-						actualLhs := evalStack[len(evalStack)-1]
-						evalStack = evalStack[:len(evalStack)-1]
-
-						if bubbleErrors(&evalStack, actualLhs) {
-							continue
-						}
-
-						lhsD, err := strconv.ParseFloat(actualLhs.Normalized, 64)
-						if err != nil {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      ERROR,
-								Literal:    "error parsing decimal",
-								Normalized: "error parsing decimal",
-							})
-							continue
-						}
-						rhsD, err := strconv.ParseFloat(lhs.Normalized, 64)
-						if err != nil {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      ERROR,
-								Literal:    "error parsing decimal",
-								Normalized: "error parsing decimal",
-							})
-							continue
-						}
-
-						if lhsD <= rhsD {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      TRUE,
-								Literal:    "true",
-								Normalized: "true",
-							})
-						} else {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      FALSE,
-								Literal:    "false",
-								Normalized: "false",
-							})
-						}
-					} else if rhs.Normalized == "greaterThan" {
-						actualLhs := evalStack[len(evalStack)-1]
-						evalStack = evalStack[:len(evalStack)-1]
-
-						if bubbleErrors(&evalStack, actualLhs) {
-							continue
-						}
-
-						lhsD, err := strconv.ParseFloat(actualLhs.Normalized, 64)
-						if err != nil {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      ERROR,
-								Literal:    "error parsing decimal",
-								Normalized: "error parsing decimal",
-							})
-							continue
-						}
-						rhsD, err := strconv.ParseFloat(lhs.Normalized, 64)
-						if err != nil {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      ERROR,
-								Literal:    "error parsing decimal",
-								Normalized: "error parsing decimal",
-							})
-							continue
-						}
-
-						if lhsD > rhsD {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      TRUE,
-								Literal:    "true",
-								Normalized: "true",
-							})
-						} else {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      FALSE,
-								Literal:    "false",
-								Normalized: "false",
-							})
-						}
-					} else if rhs.Normalized == "greaterThanOrEqual" {
-						actualLhs := evalStack[len(evalStack)-1]
-						evalStack = evalStack[:len(evalStack)-1]
-
-						if bubbleErrors(&evalStack, actualLhs) {
-							continue
-						}
-
-						lhsD, err := strconv.ParseFloat(actualLhs.Normalized, 64)
-						if err != nil {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      ERROR,
-								Literal:    "error parsing decimal",
-								Normalized: "error parsing decimal",
-							})
-							continue
-						}
-						rhsD, err := strconv.ParseFloat(lhs.Normalized, 64)
-						if err != nil {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      ERROR,
-								Literal:    "error parsing decimal",
-								Normalized: "error parsing decimal",
-							})
-							continue
-						}
-
-						if lhsD >= rhsD {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      TRUE,
-								Literal:    "true",
-								Normalized: "true",
-							})
-						} else {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      FALSE,
-								Literal:    "false",
-								Normalized: "false",
-							})
-						}
-					} else {
-						evalStack = append(evalStack, SequenceItem{
-							Token:      ERROR,
-							Literal:    fmt.Sprintf("unknown decimal function: %s", rhs.Literal),
-							Normalized: fmt.Sprintf("unknown decimal function: %s", rhs.Literal),
-						})
-						continue
-					}
-				} else {
+				evalStack = append(evalStack, item)
+			} else if lhs.Token == ENTITY && rhs.Token == ATTRIBUTE {
+				if e.es == nil {
 					evalStack = append(evalStack, SequenceItem{
 						Token:      ERROR,
-						Literal:    fmt.Sprintf("unknown function: %s", rhs.Literal),
-						Normalized: fmt.Sprintf("unknown function: %s", rhs.Literal),
+						Literal:    fmt.Sprintf("invalid attribute access (no entities available): (%v)", s.Token),
+						Normalized: fmt.Sprintf("invalid attribute access (no entities available): (%v)", s.Token),
 					})
 					continue
+				} else {
+					item, err := e.getEntityAttributeSequenceItem(lhs.Normalized, rhs.Normalized)
+					if err != nil {
+						evalStack = append(evalStack, errorSequenceItem(err))
+						continue
+					}
+					evalStack = append(evalStack, item)
+				}
+			} else if lhs.Token == ATTRIBUTE && rhs.Token == ATTRIBUTE {
+				item, err := e.getAttributeAttributeSequenceItem(lhs.Normalized, rhs.Normalized)
+				if err != nil {
+					evalStack = append(evalStack, errorSequenceItem(err))
+					continue
+				}
+				evalStack = append(evalStack, item)
+			} else if lhs.Token == RECORD && rhs.Token == ATTRIBUTE {
+				item, err := e.getRecordAttributeSequenceItem(lhs.RecordKeyValuePairs, rhs.Normalized)
+				if err != nil {
+					evalStack = append(evalStack, errorSequenceItem(err))
+					continue
+				}
+				evalStack = append(evalStack, item)
+			} else if rhs.Token == FUNCTION {
+				// lhs is the last of the receiver and arguments the method applies to, the rest being beneath it
+				if rhs.arity < 1 || len(evalStack) < rhs.arity-1 {
+					return SequenceItem{}, &EvalError{Msg: "invalid stack state"}
+				}
+				items := append(append([]SequenceItem{}, evalStack[len(evalStack)-(rhs.arity-1):]...), lhs)
+				evalStack = evalStack[:len(evalStack)-(rhs.arity-1)]
+
+				if bubbleErrors(&evalStack, items...) {
+					continue
+				}
+
+				item, err := e.callExtensionMethod(items[0], rhs.Normalized, items[1:])
+				if err != nil {
+					evalStack = append(evalStack, errorSequenceItem(err))
+					continue
 				}
+				evalStack = append(evalStack, item)
 			} else {
 				evalStack = append(evalStack, SequenceItem{
 					Token:      ERROR,
-					Literal:    fmt.Sprintf("invalid period use, unknown function or attribute access: %q (%v)", lhs.Token, lhs.Token),
-					Normalized: fmt.Sprintf("invalid period use, unknown function or attribute access: %q (%v)", lhs.Token, lhs.Token),
+					Literal:    fmt.Sprintf("invalid period use, unknown function or attribute access: (%v)", lhs.Token),
+					Normalized: fmt.Sprintf("invalid period use, unknown function or attribute access: (%v)", lhs.Token),
 				})
 				continue
 			}
@@ -1250,7 +1233,7 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 						_, ok := record.RecordKeyValuePairs[rhs.Normalized]
 						if !ok { // set only if not already set
 							// evaluate the inner expr value
-							condEvalResult, err := e.condEval(ConditionClause{Type: cc.Type, Sequence: vals}, principal, action, resource, context)
+							condEvalResult, err := e.condEval(ctx, ConditionClause{Type: cc.Type, Sequence: vals, Bindings: cc.Bindings}, principal, action, resource, context, depth+1)
 							if err != nil {
 								condEvalResult = SequenceItem{
 									Token:      ERROR,
@@ -1278,8 +1261,6 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 			}
 
 			if len(vals) != 0 {
-				fmt.Println("&&&&")
-				fmt.Println(vals)
 				evalStack = append(evalStack, SequenceItem{
 					Token:      ERROR,
 					Literal:    "error whilst processing record",
@@ -1289,39 +1270,6 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 			}
 
 			evalStack = append(evalStack, record)
-		case RIGHT_SQB:
-			var rawSet []SequenceItem
-			var set []string
-
-			rhs = evalStack[len(evalStack)-1]
-			evalStack = evalStack[:len(evalStack)-1]
-
-			for rhs.Token != LEFT_SQB {
-				rawSet = append(rawSet, rhs) // TODO: read until comma, recurse condEval & ensure no trailing comma / commas between elements
-				set = append(set, rhs.Normalized)
-				rhs = evalStack[len(evalStack)-1]
-				evalStack = evalStack[:len(evalStack)-1]
-			}
-
-			if bubbleErrors(&evalStack, rawSet...) {
-				continue
-			}
-
-			b, err := json.Marshal(set)
-			if err != nil {
-				evalStack = append(evalStack, SequenceItem{
-					Token:      ERROR,
-					Literal:    "error whilst processing set",
-					Normalized: "error whilst processing set",
-				})
-				continue
-			}
-
-			evalStack = append(evalStack, SequenceItem{
-				Token:      SET,
-				Literal:    string(b),
-				Normalized: string(b),
-			})
 		case LIKE:
 			rhs = evalStack[len(evalStack)-1]
 			lhs = evalStack[len(evalStack)-2]
@@ -1333,7 +1281,16 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 
 			if lhs.Token == DBLQUOTESTR {
 				if rhs.Token == DBLQUOTESTR {
-					matched, stopped := match.MatchLimit(lhs.Normalized, rhs.Normalized, 100)
+					pattern, err := likePattern(rhs.Literal)
+					if err != nil {
+						evalStack = append(evalStack, errorSequenceItem(err))
+						continue
+					}
+					limit := e.LikeComplexityLimit
+					if limit <= 0 {
+						limit = -1 // unlimited for the match package
+					}
+					matched, stopped := match.MatchLimit(lhs.Normalized, pattern, limit)
 					if stopped {
 						evalStack = append(evalStack, SequenceItem{
 							Token:      ERROR,
@@ -1395,16 +1352,12 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 								Normalized: "false",
 							})
 						} else {
-							descendants, err := e.es.GetEntityDescendents([]string{rhs.Normalized})
+							found, err := e.es.hasAncestor(ctx, lhs.Normalized, []string{rhs.Normalized})
 							if err != nil {
-								evalStack = append(evalStack, SequenceItem{
-									Token:      ERROR,
-									Literal:    err.Error(),
-									Normalized: err.Error(),
-								})
+								evalStack = append(evalStack, errorSequenceItem(err))
 								continue
 							}
-							if containsEntity(descendants, lhs.Normalized) {
+							if found {
 								evalStack = append(evalStack, SequenceItem{
 									Token:      TRUE,
 									Literal:    "true",
@@ -1419,6 +1372,30 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 							}
 						}
 					}
+				} else if rhs.Token == SET {
+					var rawSet []interface{}
+					if err := json.Unmarshal([]byte(rhs.Normalized), &rawSet); err != nil {
+						evalStack = append(evalStack, errorSequenceItem(err))
+						continue
+					}
+
+					var parents []string
+					for _, setItem := range rawSet {
+						if parent, ok := setItem.(string); ok {
+							parents = append(parents, parent)
+						}
+					}
+
+					found := contains(parents, lhs.Normalized)
+					if !found && e.es != nil && len(parents) > 0 {
+						var err error
+						if found, err = e.es.hasAncestor(ctx, lhs.Normalized, parents); err != nil {
+							evalStack = append(evalStack, errorSequenceItem(err))
+							continue
+						}
+					}
+
+					evalStack = append(evalStack, boolSequenceItem(found))
 				} else {
 					evalStack = append(evalStack, SequenceItem{
 						Token:      FALSE,
@@ -1434,6 +1411,37 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 				})
 				continue
 			}
+		case IS:
+			rhs = evalStack[len(evalStack)-1]
+			lhs = evalStack[len(evalStack)-2]
+			evalStack = evalStack[:len(evalStack)-2]
+
+			if bubbleErrors(&evalStack, lhs, rhs) {
+				continue
+			}
+
+			if lhs.Token == ENTITY && rhs.Token == IDENT {
+				if entityType(lhs.Normalized) == rhs.Normalized {
+					evalStack = append(evalStack, SequenceItem{
+						Token:      TRUE,
+						Literal:    "true",
+						Normalized: "true",
+					})
+				} else {
+					evalStack = append(evalStack, SequenceItem{
+						Token:      FALSE,
+						Literal:    "false",
+						Normalized: "false",
+					})
+				}
+			} else {
+				evalStack = append(evalStack, SequenceItem{
+					Token:      ERROR,
+					Literal:    fmt.Sprintf("unknown token near is: (%v)", s.Token),
+					Normalized: fmt.Sprintf("unknown token near is: (%v)", s.Token),
+				})
+				continue
+			}
 		case HAS:
 			rhs = evalStack[len(evalStack)-1]
 			lhs = evalStack[len(evalStack)-2]
@@ -1452,13 +1460,9 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 							Normalized: "false",
 						})
 					} else {
-						entities, err := e.es.GetEntities()
+						entity, _, err := e.es.GetEntity(lhs.Normalized)
 						if err != nil {
-							evalStack = append(evalStack, SequenceItem{
-								Token:      ERROR,
-								Literal:    err.Error(),
-								Normalized: err.Error(),
-							})
+							evalStack = append(evalStack, errorSequenceItem(err))
 							continue
 						}
 
@@ -1468,16 +1472,12 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 							Normalized: "false",
 						}
 
-						for _, entity := range entities {
-							if entity.Identifier == lhs.Normalized {
-								for _, attribute := range entity.Attributes {
-									if attribute.Name == rhs.Normalized {
-										item = SequenceItem{
-											Token:      TRUE,
-											Literal:    "true",
-											Normalized: "true",
-										}
-									}
+						for _, attribute := range entity.Attributes {
+							if attribute.Name == rhs.Normalized {
+								item = SequenceItem{
+									Token:      TRUE,
+									Literal:    "true",
+									Normalized: "true",
 								}
 							}
 						}
@@ -1492,6 +1492,32 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 					})
 					continue
 				}
+			} else if (lhs.Token == CONTEXT || lhs.Token == ATTRIBUTE || lhs.Token == RECORD) && (rhs.Token == ATTRIBUTE || rhs.Token == DBLQUOTESTR) {
+				var found bool
+				if lhs.Token == RECORD {
+					_, found = lhs.RecordKeyValuePairs[rhs.Normalized]
+				} else {
+					var err error
+					found, err = hasAttributeAttribute(lhs.Normalized, rhs.Normalized)
+					if err != nil {
+						evalStack = append(evalStack, errorSequenceItem(err))
+						continue
+					}
+				}
+
+				if found {
+					evalStack = append(evalStack, SequenceItem{
+						Token:      TRUE,
+						Literal:    "true",
+						Normalized: "true",
+					})
+				} else {
+					evalStack = append(evalStack, SequenceItem{
+						Token:      FALSE,
+						Literal:    "false",
+						Normalized: "false",
+					})
+				}
 			} else {
 				evalStack = append(evalStack, SequenceItem{
 					Token:      ERROR,
@@ -1500,7 +1526,7 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 				})
 				continue
 			}
-		case LT, LTE, GT, GTE, PLUS, DASH, MULTIPLIER:
+		case LT, LTE, GT, GTE, PLUS, DASH, MULTIPLIER, SLASH, PERCENT:
 			rhs = evalStack[len(evalStack)-1]
 			lhs = evalStack[len(evalStack)-2]
 			evalStack = evalStack[:len(evalStack)-2]
@@ -1509,24 +1535,23 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 				continue
 			}
 
-			if lhs.Token == LONG {
+			if s.Token == PLUS && lhs.Token == DBLQUOTESTR && rhs.Token == DBLQUOTESTR {
+				b, _ := json.Marshal(lhs.Normalized + rhs.Normalized)
+				evalStack = append(evalStack, SequenceItem{
+					Token:      DBLQUOTESTR,
+					Literal:    string(b),
+					Normalized: lhs.Normalized + rhs.Normalized,
+				})
+			} else if lhs.Token == LONG {
 				if rhs.Token == LONG {
 					lhsL, err := strconv.ParseInt(lhs.Normalized, 10, 64)
 					if err != nil {
-						evalStack = append(evalStack, SequenceItem{
-							Token:      ERROR,
-							Literal:    err.Error(),
-							Normalized: err.Error(),
-						})
+						evalStack = append(evalStack, errorSequenceItem(err))
 						continue
 					}
 					rhsL, err := strconv.ParseInt(rhs.Normalized, 10, 64)
 					if err != nil {
-						evalStack = append(evalStack, SequenceItem{
-							Token:      ERROR,
-							Literal:    err.Error(),
-							Normalized: err.Error(),
-						})
+						evalStack = append(evalStack, errorSequenceItem(err))
 						continue
 					}
 
@@ -1586,23 +1611,43 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 								Normalized: "false",
 							})
 						}
-					} else if s.Token == PLUS {
-						evalStack = append(evalStack, SequenceItem{
-							Token:      LONG,
-							Literal:    strconv.FormatInt(lhsL+rhsL, 10),
-							Normalized: strconv.FormatInt(lhsL+rhsL, 10),
-						})
-					} else if s.Token == DASH {
-						evalStack = append(evalStack, SequenceItem{
-							Token:      LONG,
-							Literal:    strconv.FormatInt(lhsL-rhsL, 10),
-							Normalized: strconv.FormatInt(lhsL-rhsL, 10),
-						})
-					} else if s.Token == MULTIPLIER {
+					} else {
+						var result int64
+						var ok bool
+						if s.Token == PLUS {
+							result, ok = addInt64(lhsL, rhsL)
+						} else if s.Token == DASH {
+							result, ok = subInt64(lhsL, rhsL)
+						} else if s.Token == SLASH || s.Token == PERCENT {
+							if rhsL == 0 {
+								evalStack = append(evalStack, SequenceItem{
+									Token:      ERROR,
+									Literal:    fmt.Sprintf("division by zero: %d %s 0", lhsL, s.Literal),
+									Normalized: fmt.Sprintf("division by zero: %d %s 0", lhsL, s.Literal),
+								})
+								continue
+							}
+							if s.Token == SLASH {
+								result, ok = divInt64(lhsL, rhsL)
+							} else {
+								// the remainder has the sign of the dividend, and cannot overflow
+								result, ok = lhsL%rhsL, true
+							}
+						} else {
+							result, ok = mulInt64(lhsL, rhsL)
+						}
+						if !ok {
+							evalStack = append(evalStack, SequenceItem{
+								Token:      ERROR,
+								Literal:    fmt.Sprintf("integer overflow: %d %s %d", lhsL, s.Literal, rhsL),
+								Normalized: fmt.Sprintf("integer overflow: %d %s %d", lhsL, s.Literal, rhsL),
+							})
+							continue
+						}
 						evalStack = append(evalStack, SequenceItem{
 							Token:      LONG,
-							Literal:    strconv.FormatInt(lhsL*rhsL, 10),
-							Normalized: strconv.FormatInt(lhsL*rhsL, 10),
+							Literal:    strconv.FormatInt(result, 10),
+							Normalized: strconv.FormatInt(result, 10),
 						})
 					}
 				} else {
@@ -1879,6 +1924,7 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 					Normalized: "false",
 				})
 			} else {
+				// without short-circuiting, errors from either side are reported even when lhs is false
 				if bubbleErrors(&evalStack, lhs, rhs) {
 					continue
 				}
@@ -1946,17 +1992,19 @@ func (e *Evaluator) condEval(cc ConditionClause, principal, action, resource, co
 				}
 			}
 		default:
-			return SequenceItem{}, fmt.Errorf("unknown token: (%v)", s.Token)
+			return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("unknown token: (%v)", s.Token)}
 		}
 	}
 
 	if len(evalStack) != 1 {
-		fmt.Println(evalStack)
-		return SequenceItem{}, fmt.Errorf("invalid stack state")
+		return SequenceItem{}, &EvalError{Msg: "invalid stack state"}
 	}
 
 	if evalStack[0].Token == ERROR {
-		return SequenceItem{}, fmt.Errorf(evalStack[0].Literal)
+		if evalStack[0].err != nil {
+			return SequenceItem{}, evalStack[0].err
+		}
+		return SequenceItem{}, &EvalError{Msg: evalStack[0].Literal}
 	}
 
 	return evalStack[0], nil
@@ -1969,83 +2017,98 @@ func (e *Evaluator) getRecordAttributeSequenceItem(recordKeyValuePairs map[strin
 		}
 	}
 
-	return SequenceItem{}, fmt.Errorf("attribute not set")
+	return SequenceItem{}, &AttributeError{Attribute: attributeName, Msg: "attribute not set"}
 }
 
 func (e *Evaluator) getEntityAttributeSequenceItem(entityName, attributeName string) (SequenceItem, error) {
 	if e.es == nil {
-		return SequenceItem{}, fmt.Errorf("attribute access on invalid entity store")
+		return SequenceItem{}, &EntityError{Identifier: entityName, Msg: "attribute access on invalid entity store"}
 	}
 
-	entities, err := e.es.GetEntities()
+	entity, found, err := e.es.GetEntity(entityName)
 	if err != nil {
 		return SequenceItem{}, err
 	}
 
-	for _, entity := range entities {
-		if entity.Identifier == entityName {
-			for _, attribute := range entity.Attributes {
-				if attribute.Name == attributeName {
-					if attribute.StringValue != nil {
-						b, _ := json.Marshal(*attribute.StringValue)
+	if found {
+		for _, attribute := range entity.Attributes {
+			if attribute.Name == attributeName {
+				if attribute.StringValue != nil {
+					b, _ := json.Marshal(*attribute.StringValue)
+					return SequenceItem{
+						Token:      DBLQUOTESTR,
+						Literal:    string(b),
+						Normalized: *attribute.StringValue,
+					}, nil
+				}
+				if attribute.LongValue != nil {
+					return SequenceItem{
+						Token:      LONG,
+						Literal:    strconv.FormatInt(*attribute.LongValue, 10),
+						Normalized: strconv.FormatInt(*attribute.LongValue, 10),
+					}, nil
+				}
+				if attribute.BooleanValue != nil {
+					if *attribute.BooleanValue {
 						return SequenceItem{
-							Token:      DBLQUOTESTR,
-							Literal:    string(b),
-							Normalized: *attribute.StringValue,
+							Token:      TRUE,
+							Literal:    "true",
+							Normalized: "true",
 						}, nil
-					}
-					if attribute.LongValue != nil {
+					} else {
 						return SequenceItem{
-							Token:      LONG,
-							Literal:    strconv.FormatInt(*attribute.LongValue, 10),
-							Normalized: strconv.FormatInt(*attribute.LongValue, 10),
+							Token:      FALSE,
+							Literal:    "false",
+							Normalized: "false",
 						}, nil
 					}
-					if attribute.BooleanValue != nil {
-						if *attribute.BooleanValue {
-							return SequenceItem{
-								Token:      TRUE,
-								Literal:    "true",
-								Normalized: "true",
-							}, nil
-						} else {
-							return SequenceItem{
-								Token:      FALSE,
-								Literal:    "false",
-								Normalized: "false",
-							}, nil
-						}
+				}
+				if attribute.RecordValue != nil {
+					b, err := json.Marshal(*attribute.RecordValue)
+					if err != nil {
+						return SequenceItem{}, err
 					}
-					if attribute.RecordValue != nil {
-						b, err := json.Marshal(*attribute.RecordValue)
-						if err != nil {
-							return SequenceItem{}, err
-						}
-						return SequenceItem{
-							Token:      ATTRIBUTE,
-							Literal:    string(b),
-							Normalized: string(b),
-						}, nil
+					return SequenceItem{
+						Token:      ATTRIBUTE,
+						Literal:    string(b),
+						Normalized: string(b),
+					}, nil
+				}
+				if attribute.SetValue != nil {
+					b, err := json.Marshal(*attribute.SetValue)
+					if err != nil {
+						return SequenceItem{}, err
 					}
-					if attribute.SetValue != nil {
-						b, err := json.Marshal(*attribute.SetValue)
-						if err != nil {
-							return SequenceItem{}, err
-						}
-						return SequenceItem{
-							Token:      SET,
-							Literal:    string(b),
-							Normalized: string(b),
-						}, nil
+					return SequenceItem{
+						Token:      SET,
+						Literal:    string(b),
+						Normalized: string(b),
+					}, nil
+				}
+				if attribute.EntityValue != nil {
+					return SequenceItem{
+						Token:      ENTITY,
+						Literal:    *attribute.EntityValue,
+						Normalized: *attribute.EntityValue,
+					}, nil
+				}
+				if attribute.ExtensionValue != nil {
+					constructor, ok := e.constructors[attribute.ExtensionValue.Fn]
+					if !ok {
+						return SequenceItem{}, &AttributeError{Entity: entityName, Attribute: attributeName, Msg: fmt.Sprintf("unknown function: %s", attribute.ExtensionValue.Fn)}
 					}
-					break
+					return constructor(e, SequenceItem{
+						Token:      DBLQUOTESTR,
+						Literal:    quoteString(attribute.ExtensionValue.Arg),
+						Normalized: attribute.ExtensionValue.Arg,
+					})
 				}
+				break
 			}
-			break
 		}
 	}
 
-	return SequenceItem{}, fmt.Errorf("attribute not set")
+	return SequenceItem{}, &AttributeError{Entity: entityName, Attribute: attributeName, Msg: "attribute not set"}
 }
 
 func (e *Evaluator) getAttributeAttributeSequenceItem(sourceAttribute, attributeName string) (SequenceItem, error) {
@@ -2123,32 +2186,183 @@ func (e *Evaluator) getAttributeAttributeSequenceItem(sourceAttribute, attribute
 					Normalized: string(b),
 				}, nil
 			default:
-				return SequenceItem{}, fmt.Errorf("unknown type in attribute block: %v (%s)", attrVal, reflect.TypeOf(attrVal).String())
+				return SequenceItem{}, &AttributeError{
+					Attribute: attributeName,
+					Msg:       fmt.Sprintf("unknown type in attribute block: %v (%s)", attrVal, reflect.TypeOf(attrVal).String()),
+				}
+			}
+
+		}
+	}
+
+	return SequenceItem{}, &AttributeError{Attribute: attributeName, Msg: "attribute not set"}
+}
+
+// hasAttributeAttribute returns true if the JSON object (such as the context) contains the named attribute. An
+// attribute which is explicitly null is treated as not present.
+func hasAttributeAttribute(sourceAttribute, attributeName string) (bool, error) {
+	if sourceAttribute == "" {
+		return false, nil
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(sourceAttribute), &obj); err != nil {
+		return false, err
+	}
+
+	attrVal, ok := obj[attributeName]
+	return ok && attrVal != nil, nil
+}
+
+// addInt64 returns a + b, and false if the result overflows.
+func addInt64(a, b int64) (int64, bool) {
+	if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+		return 0, false
+	}
+	return a + b, true
+}
+
+// subInt64 returns a - b, and false if the result overflows.
+func subInt64(a, b int64) (int64, bool) {
+	if (b < 0 && a > math.MaxInt64+b) || (b > 0 && a < math.MinInt64+b) {
+		return 0, false
+	}
+	return a - b, true
+}
+
+// mulInt64 returns a * b, and false if the result overflows.
+func mulInt64(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	result := a * b
+	if (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) || result/b != a {
+		return 0, false
+	}
+	return result, true
+}
+
+// divInt64 returns a / b truncated toward zero, and false if the result overflows. b must not be zero.
+func divInt64(a, b int64) (int64, bool) {
+	if a == math.MinInt64 && b == -1 {
+		return 0, false
+	}
+	return a / b, true
+}
+
+// matchingBracket returns the index of the bracket closing the one opened at seq[i], or -1 if it is never closed.
+func matchingBracket(seq []SequenceItem, i int) int {
+	depth := 0
+	for j := i; j < len(seq); j++ {
+		switch seq[j].Token {
+		case LEFT_PAREN, LEFT_SQB, LEFT_BRACE:
+			depth++
+		case RIGHT_PAREN, RIGHT_SQB, RIGHT_BRACE:
+			depth--
+		}
+		if depth == 0 {
+			return j
+		}
+	}
+
+	return -1
+}
+
+// operandCount returns the number of items an operator pops from the evaluation stack.
+func operandCount(tok Token) int {
+	switch tok {
+	case UNARYMINUS, EXCLAMATION, ELSE, RIGHT_BRACE:
+		return 1
+	case IF, THEN, PERIOD, LIKE, IN, IS, HAS, LT, LTE, GT, GTE, PLUS, DASH, MULTIPLIER, SLASH, PERCENT, EQUALITY, INEQUALITY, AND, OR:
+		return 2
+	}
+
+	return 0
+}
+
+// functionArity returns the number of items the function call at index i of seq applies to, being the receiver and
+// arguments of a method call. Calls which do not follow a period construct extension values and have an arity of 0.
+func functionArity(seq []SequenceItem, i int) int {
+	if i == 0 || seq[i-1].Token != PERIOD {
+		return 0
+	}
+
+	depth, commas, empty := 0, 0, true
+	for j := i + 1; j < len(seq); j++ {
+		switch seq[j].Token {
+		case LEFT_PAREN, LEFT_SQB, LEFT_BRACE:
+			depth++
+		case RIGHT_PAREN, RIGHT_SQB, RIGHT_BRACE:
+			depth--
+		case COMMA:
+			if depth == 1 {
+				commas++
 			}
+		}
+		if depth == 0 {
+			break
+		}
+		if j > i+1 {
+			empty = false
+		}
+	}
+
+	if empty {
+		return 1
+	}
+	return commas + 2
+}
 
+// boolSequenceItem returns a TRUE or FALSE SequenceItem for b.
+func boolSequenceItem(b bool) SequenceItem {
+	if b {
+		return SequenceItem{
+			Token:      TRUE,
+			Literal:    "true",
+			Normalized: "true",
 		}
 	}
+	return SequenceItem{
+		Token:      FALSE,
+		Literal:    "false",
+		Normalized: "false",
+	}
+}
 
-	return SequenceItem{}, fmt.Errorf("attribute not set")
+// errorSequenceItem returns an ERROR SequenceItem for err, retaining err so that it can be returned as-is from
+// condEval.
+func errorSequenceItem(err error) SequenceItem {
+	return SequenceItem{
+		Token:      ERROR,
+		Literal:    err.Error(),
+		Normalized: err.Error(),
+		err:        err,
+	}
 }
 
 // bubbleErrors interprets lhs, rhs etc. SequenceItems and returns any errors to the evalQueue. The function returns true if there was a bubbled error.
 func bubbleErrors(evalStack *[]SequenceItem, items ...SequenceItem) bool {
 	bubbleOccurred := false
 	var foundErrors []string
+	var err error
 
 	for _, item := range items {
 		if item.Token == ERROR || item.Token == THEN_TRUE_ELSE_ERROR || item.Token == THEN_FALSE_ELSE_ERROR || item.Token == THEN_ERROR_ELSE_TRUE || item.Token == THEN_ERROR_ELSE_FALSE {
 			foundErrors = append(foundErrors, item.Literal)
+			err = item.err
 			bubbleOccurred = true
 		}
 	}
 
 	if bubbleOccurred {
+		if len(foundErrors) > 1 {
+			err = nil
+		}
 		*evalStack = append(*evalStack, SequenceItem{
 			Token:      ERROR,
 			Literal:    strings.Join(foundErrors, ". "),
 			Normalized: strings.Join(foundErrors, ". "),
+			err:        err,
 		})
 	}
 
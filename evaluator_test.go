@@ -1,10 +1,19 @@
 package polai_test
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/iann0036/polai"
 )
@@ -764,6 +773,72 @@ func TestEvaluator_EvaluateStatement(t *testing.T) {
 			expectedResult: true,
 		},
 
+		{
+			name: "anonymous record multiple key access",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				{a: 1, "b": "two", c: true}.b == "two" && {a: 1, "b": "two", c: true}.a == 1 && {a: 1, "b": "two", c: true}.c
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "anonymous record multiple expression values",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				{a: 1 + 2, b: {c: context.x, d: [1, 2]}, e: !false}.b.c == 4
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"x": 4}`,
+			expectedResult: true,
+		},
+
+		{
+			name: "anonymous record identifier keys",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				let a = 5;
+				{key_1: 1, Key2: "x", a: a}.Key2 == "x" && {key_1: 1, Key2: "x", a: a}.a == 5 && {key_1: 1, "b": 2}["key_1"] == 1
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "anonymous record all keys accessible",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				{"a": 1, "b": 2}.a == 1 && {"a": 1, "b": 2}.b == 2 && {"a": 1, "b": 2} has a && {"a": 1, "b": 2} has b
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
 		{
 			name: "anonymous record quoted nested mixed key access",
 			s: `
@@ -1257,6 +1332,91 @@ func TestEvaluator_EvaluateStatement(t *testing.T) {
 			expectedResult: true,
 		},
 
+		{
+			name: "IP Function isUnspecified",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				ip("0.0.0.0").isUnspecified() &&
+				ip("::").isUnspecified() &&
+				!ip("10.0.0.1").isUnspecified() &&
+				!ip("0.0.0.0/8").isUnspecified()
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "IP Function isLinkLocal",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				ip("169.254.10.20").isLinkLocal() &&
+				ip("169.254.0.0/16").isLinkLocal() &&
+				ip("fe80::1").isLinkLocal() &&
+				ip("fe80::/10").isLinkLocal() &&
+				!ip("169.0.0.0/8").isLinkLocal() &&
+				!ip("10.0.0.1").isLinkLocal() &&
+				!ip("2001:db8::1").isLinkLocal()
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "IP Function isGlobalUnicast and isPrivate",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				ip("8.8.8.8").isGlobalUnicast() &&
+				ip("2001:db8::1").isGlobalUnicast() &&
+				!ip("127.0.0.1").isGlobalUnicast() &&
+				!ip("224.0.0.1").isGlobalUnicast() &&
+				ip("10.0.0.0/8").isPrivate() &&
+				ip("192.168.0.0/16").isPrivate() &&
+				ip("fd00::1").isPrivate() &&
+				!ip("8.8.8.8").isPrivate() &&
+				!ip("10.0.0.0/7").isPrivate()
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "IP Function IPv4-mapped IPv6",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				ip("::ffff:10.0.0.1").isIpv4() &&
+				!ip("::ffff:10.0.0.1").isIpv6() &&
+				ip("::ffff:10.0.0.1") == ip("10.0.0.1") &&
+				ip("::ffff:10.0.0.0/104") == ip("10.0.0.0/8") &&
+				ip("::ffff:10.0.0.1").isInRange(ip("10.0.0.0/8"))
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
 		{
 			name: "Decimal Function",
 			s: `
@@ -1273,6 +1433,57 @@ func TestEvaluator_EvaluateStatement(t *testing.T) {
 			expectedResult: true,
 		},
 
+		{
+			name: "Decimal Function infinity",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				decimal("Inf").greaterThan(decimal("1.0"))
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: false,
+			err:            "decimal Inf is not a finite number",
+		},
+
+		{
+			name: "Decimal Function negative infinity",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				decimal("-Inf").lessThan(decimal("1.0"))
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: false,
+			err:            "decimal -Inf is not a finite number",
+		},
+
+		{
+			name: "Decimal Function NaN",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				decimal("1.0").lessThan(decimal("NaN"))
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: false,
+			err:            "decimal NaN is not a finite number",
+		},
+
 		{
 			name: "Decimal Function (negate)",
 			s: `
@@ -1752,25 +1963,2727 @@ func TestEvaluator_EvaluateStatement(t *testing.T) {
 		},
 
 		{
-			name: "Errors",
-			s:    `foo`,
-			err:  `found "foo", expected permit or forbid`,
+			name: "is operator (condition)",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				principal is User &&
+				resource is Org::Folder &&
+				!(resource is Folder) &&
+				!(resource is Org) &&
+				!(principal is Folder)
+			};`,
+			principal:      "User::\"alice\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Org::Folder::\"My::Folder\"",
+			expectedResult: true,
 		},
-	}
 
-	for i, tt := range tests {
-		e := polai.NewEvaluator(strings.NewReader(tt.s))
-		if tt.entities != "" {
-			e.SetEntities(strings.NewReader(tt.entities))
-		}
-		if tt.disableShortCircuiting {
-			e.AllowShortCircuiting = false
-		}
-		result, err := e.Evaluate(tt.principal, tt.action, tt.resource, tt.context)
-		if !reflect.DeepEqual(tt.err, errstring(err)) {
-			t.Errorf("%d. %s\n%q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.name, tt.s, tt.err, err)
-		} else if tt.err == "" && tt.expectedResult != result {
-			t.Errorf("%d. %s\n%q\n\result mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.name, tt.s, tt.expectedResult, result)
-		}
+		{
+			name: "is operator (condition, mismatch)",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				principal is Org::User
+			};`,
+			principal:      "User::\"alice\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: false,
+		},
+
+		{
+			name: "is operator (condition, non-entity)",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				"alice" is User
+			};`,
+			principal: "User::\"alice\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       fmt.Sprintf("unknown token near is: (%v)", polai.IS),
+		},
+
+		{
+			name: "Unbound template",
+			s: `
+			permit (
+				principal == ?principal,
+				action,
+				resource
+			);`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "policy template slots must be bound before evaluation",
+		},
+
+		{
+			name: "if-then-else then error else false",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				!(if false then principal.invalidprop else false)
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "if-then-else then error else false (shortcircuit disabled)",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				if false then principal.invalidprop else false
+			};`,
+			disableShortCircuiting: true,
+			principal:              "Principal::\"MyPrincipal\"",
+			action:                 "Action::\"MyAction\"",
+			resource:               "Resource::\"MyResource\"",
+			err:                    fmt.Sprintf(`invalid attribute access (no entities available): (%v)`, polai.PERIOD),
+		},
+
+		{
+			name: "if-then-else then error else error",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				if true then principal.invalidprop else principal.invalidprop2
+			};`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       fmt.Sprintf(`invalid attribute access (no entities available): (%v). invalid attribute access (no entities available): (%v)`, polai.PERIOD, polai.PERIOD),
+		},
+
+		{
+			name:           "CRLF line endings",
+			s:              "permit (\r\n\tprincipal,\r\n\taction,\r\n\tresource\r\n) when {\r\n\t\"a\rb\" like \"a*b\"\r\n};\r\n",
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "entity reference attributes",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				resource.owner == principal &&
+				resource.owner in Group::"admins" &&
+				resource.owner != User::"bob"
+			};`,
+			principal: "User::\"alice\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Task::\"t1\"",
+			entities: `[
+				{
+					"uid": "Task::\"t1\"",
+					"attrs": {
+						"owner": {"__entity": {"type": "User", "id": "alice"}}
+					}
+				},
+				{
+					"uid": "User::\"alice\"",
+					"parents": ["Group::\"admins\""]
+				}
+			]`,
+			expectedResult: true,
+		},
+
+		{
+			name: "entity reference attributes (invalid)",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				resource.owner == principal
+			};`,
+			principal: "User::\"alice\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Task::\"t1\"",
+			entities: `[
+				{
+					"uid": "Task::\"t1\"",
+					"attrs": {
+						"owner": {"__entity": {"id": "alice"}}
+					}
+				}
+			]`,
+			err: `invalid entity reference type in attribute block: map[id:alice]`,
+		},
+
+		{
+			name: "Decimal Function (negative)",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				decimal("-1.5").lessThan(decimal("0.5")) &&
+				decimal("-1.5").greaterThan(decimal("-1.6")) &&
+				decimal("-1.5") == decimal("-1.5000")
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "and short-circuit processing",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				!(false && context.missingAttr)
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{}`,
+			expectedResult: true,
+		},
+
+		{
+			name: "and short-circuit processing, short-circuit disabled",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				false && context.missingAttr
+			};`,
+			disableShortCircuiting: true,
+			principal:              "Principal::\"MyPrincipal\"",
+			action:                 "Action::\"MyAction\"",
+			resource:               "Resource::\"MyResource\"",
+			context:                `{}`,
+			err:                    "attribute not set",
+		},
+
+		{
+			name: "and without errors, short-circuit disabled",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				!(false && context.s == "abc")
+			};`,
+			disableShortCircuiting: true,
+			principal:              "Principal::\"MyPrincipal\"",
+			action:                 "Action::\"MyAction\"",
+			resource:               "Resource::\"MyResource\"",
+			context:                `{"s": "abc"}`,
+			expectedResult:         true,
+		},
+
+		{
+			name: "Block comments",
+			s: `
+			/* comment stuff */
+			permit (
+				principal, /* comment
+				stuff */
+				action,
+				resource
+			) when {
+				/* false || */ "/* abc */" like "/**/"
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "Unicode entity identifiers",
+			s: `
+			permit (
+				principal == Département::"Archives",
+				action,
+				resource in Région::"Nord"
+			) when {
+				principal is Département
+			};`,
+			principal:      "Département::\"Archives\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Région::\"Nord\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "hex and octal longs",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				0xFF == 255 && 0o755 == 493 && 0x10 + 0o10 == 24
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "long underscore separators",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				1_000_000 == 1000000 && -1_000 < 0
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "long trailing underscore",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				1_000_ == 1000
+			};`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "unexpected token found in condition clause \"1_000_\" (ILLEGAL) at line 7, column 5",
+		},
+
+		{
+			name: "string escape sequences",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				"\n" like "*" &&
+				"a\"b" == context.quoted &&
+				"é\u{e9}" == "éé" &&
+				"tab\there" like "tab*here" &&
+				"*" like "\*" &&
+				!("a" like "\*")
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"quoted": "a\"b"}`,
+			expectedResult: true,
+		},
+
+		{
+			name: "like literal asterisk escape",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				"price*" like "price\*" &&
+				!("price10" like "price\*") &&
+				"a*b*c" like "a\**c" &&
+				!("abc" like "a\*c") &&
+				"a\\b" like "a\\b" &&
+				!("ab" like "a\\b") &&
+				!("a*" like "a\\*") &&
+				"a\\xyz" like "a\\*" &&
+				"a\\*" like "a\\\*" &&
+				"ab" like "A\*".toLowerCase() &&
+				"a\\" like "A\\".toLowerCase() &&
+				!("ab" like "A\\".toLowerCase())
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "annotations",
+			s: `
+			@id("allow-all")
+			@advice("always permitted")
+			permit (
+				principal,
+				action,
+				resource
+			);`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "principal is in scope",
+			s: `
+			permit (
+				principal is User,
+				action,
+				resource
+			);`,
+			principal:      "User::\"alice\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "principal is in scope mismatch",
+			s: `
+			permit (
+				principal is User,
+				action,
+				resource
+			);`,
+			principal:      "Org::User::\"alice\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: false,
+		},
+
+		{
+			name: "principal is namespaced type in scope with condition",
+			s: `
+			forbid (
+				principal is Org::User,
+				action,
+				resource
+			) when {
+				principal is Org::User
+			};
+			permit (
+				principal,
+				action,
+				resource
+			);`,
+			principal:      "Org::User::\"alice\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: false,
+		},
+
+		{
+			name: "resource is in scope",
+			s: `
+			permit (
+				principal,
+				action,
+				resource is Photo
+			);`,
+			principal:      "User::\"alice\"",
+			action:         "Action::\"view\"",
+			resource:       "Photo::\"beach.jpg\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "resource is in scope mismatch",
+			s: `
+			permit (
+				principal,
+				action,
+				resource is Photo
+			);`,
+			principal:      "User::\"alice\"",
+			action:         "Action::\"view\"",
+			resource:       "Album::\"beach\"",
+			expectedResult: false,
+		},
+
+		{
+			name: "principal and resource is in scope",
+			s: `
+			permit (
+				principal is User,
+				action,
+				resource is Photo
+			) when {
+				resource is Photo
+			};`,
+			principal:      "Group::\"alice\"",
+			action:         "Action::\"view\"",
+			resource:       "Photo::\"beach.jpg\"",
+			expectedResult: false,
+		},
+
+		{
+			name: "action is in scope",
+			s: `
+			permit (
+				principal,
+				action is MyApp::Action,
+				resource
+			);`,
+			principal:      "User::\"alice\"",
+			action:         "MyApp::Action::\"view\"",
+			resource:       "Photo::\"beach.jpg\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "action is in scope mismatch",
+			s: `
+			permit (
+				principal,
+				action is MyApp::Action,
+				resource
+			);`,
+			principal:      "User::\"alice\"",
+			action:         "Action::\"view\"",
+			resource:       "Photo::\"beach.jpg\"",
+			expectedResult: false,
+		},
+
+		{
+			name: "errors in any statement abort evaluation",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			);
+			forbid (principal == "alice", action, resource);`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       `found "\"alice\"", expected entity namespace at line 7, column 25`,
+		},
+
+		{
+			name: "let bindings",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				let token = context.request.header.auth_token;
+				let valid = token == "abc" || token == "def";
+				valid && token like "a*"
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"request": {"header": {"auth_token": "abc"}}}`,
+			expectedResult: true,
+		},
+
+		{
+			name: "let binding shadowing",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				let x = 1;
+				let x = x + 1;
+				let x = x * 3;
+				x == 6
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "let binding with if-then-else",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				let limited = if context.admin then false else true;
+				let underLimit = context.count < 10;
+				if limited then underLimit else !underLimit
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"admin": true, "count": 75}`,
+			expectedResult: true,
+		},
+
+		{
+			name: "let binding only evaluated when referenced",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				let missing = context.missing;
+				false || true
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "context bracket key access",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				context["key with spaces"] == 1 &&
+				context["123"] == 2 &&
+				context["tab\tkey"]["quoted \"key\""] == 3 &&
+				context.nested["x-y"] == 4
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"key with spaces": 1, "123": 2, "tab\tkey": {"quoted \"key\"": 3}, "nested": {"x-y": 4}}`,
+			expectedResult: true,
+		},
+
+		{
+			name: "anonymous record bracket key access",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				{"a b": {"c": true}}["a b"]["c"]
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "context has",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				context has ssl &&
+				context has "key with spaces" &&
+				!(context has missing) &&
+				!(context has nothing) &&
+				context.req has header &&
+				!(context.req has body)
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"ssl": false, "key with spaces": 1, "nothing": null, "req": {"header": "x", "body": null}}`,
+			expectedResult: true,
+		},
+
+		{
+			name: "context has with empty context",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) unless {
+				context has ssl
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{}`,
+			expectedResult: true,
+		},
+
+		{
+			name: "context has guards attribute access",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				context has ssl && context.ssl
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"other": true}`,
+			expectedResult: false,
+		},
+
+		{
+			name: "anonymous record has",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				{"x": 1, y: {"z": true}} has x &&
+				{"a b": 1} has "a b" &&
+				!({"x": 1} has y) &&
+				{y: {"z": true}}.y has z &&
+				!({} has x)
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "entity attribute record has",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				principal has r && principal.r has sub &&
+				!(principal.r has missing) &&
+				!(principal.r has empty) &&
+				principal.r.sub has deep
+			};`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			entities: `
+			[
+				{
+					"uid": "Principal::\"MyPrincipal\"",
+					"attrs": {
+						"r": {
+							"sub": {
+								"deep": 1
+							},
+							"empty": null
+						}
+					}
+				}
+			]`,
+			expectedResult: true,
+		},
+
+		{
+			name: "context record has",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				context has r && context.r has s && !(context.r has t)
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"r": {"s": "abc", "t": null}}`,
+			expectedResult: true,
+		},
+
+		{
+			name:           "long arithmetic at bounds",
+			s:              fmt.Sprintf(`permit (principal, action, resource) when { %d - 1 + 1 == %d && %d + 1 - 1 == %d };`, int64(math.MaxInt64), int64(math.MaxInt64), int64(math.MinInt64), int64(math.MinInt64)),
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name:      "long addition overflow",
+			s:         fmt.Sprintf(`permit (principal, action, resource) when { %d + 1 > 0 };`, int64(math.MaxInt64)),
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       fmt.Sprintf("integer overflow: %d + 1", int64(math.MaxInt64)),
+		},
+
+		{
+			name:      "long subtraction overflow",
+			s:         fmt.Sprintf(`permit (principal, action, resource) when { %d - 1 < 0 };`, int64(math.MinInt64)),
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       fmt.Sprintf("integer overflow: %d - 1", int64(math.MinInt64)),
+		},
+
+		{
+			name:      "long multiplication overflow",
+			s:         fmt.Sprintf(`permit (principal, action, resource) when { %d * 2 > 0 };`, int64(math.MaxInt64)),
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       fmt.Sprintf("integer overflow: %d * 2", int64(math.MaxInt64)),
+		},
+
+		{
+			name:      "long multiplication overflow (negative)",
+			s:         fmt.Sprintf(`permit (principal, action, resource) when { %d * -1 > 0 };`, int64(math.MinInt64)),
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       fmt.Sprintf("integer overflow: %d * -1", int64(math.MinInt64)),
+		},
+
+		{
+			name:           "unary minus",
+			s:              `permit (principal, action, resource) when { -(2+3) == -5 && !true && -1 < 0 || -context.i == -3 && 5 - -context.i == 8 && -context.i * 2 == -6 };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"i": 3}`,
+			expectedResult: true,
+		},
+
+		{
+			name:           "unary minus precedence",
+			s:              `permit (principal, action, resource) when { -(2+3) == -5 && (!true && -1 < 0) == false };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name:      "unary minus of non-long",
+			s:         `permit (principal, action, resource) when { -true == 1 };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "attempted to negate non-long",
+		},
+
+		{
+			name:      "unary minus overflow",
+			s:         fmt.Sprintf(`permit (principal, action, resource) when { -(%d) > 0 };`, int64(math.MinInt64)),
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       fmt.Sprintf("integer overflow: -(%d)", int64(math.MinInt64)),
+		},
+
+		{
+			name:           "long division",
+			s:              `permit (principal, action, resource) when { 7 / 2 == 3 && -7 / 2 == -3 && 7 / -2 == -3 && 2 * 9 / 3 == 6 && 1 + 6 / 2 == 4 && context.n / 10 == 4 };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"n": 42}`,
+			expectedResult: true,
+		},
+
+		{
+			name:      "long division by zero",
+			s:         `permit (principal, action, resource) when { 1 / 0 == 0 };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "division by zero: 1 / 0",
+		},
+
+		{
+			name:      "long division overflow",
+			s:         fmt.Sprintf(`permit (principal, action, resource) when { %d / -1 > 0 };`, int64(math.MinInt64)),
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       fmt.Sprintf("integer overflow: %d / -1", int64(math.MinInt64)),
+		},
+
+		{
+			name:           "long modulo",
+			s:              `permit (principal, action, resource) when { 7 % 3 == 1 && -7 % 3 == -1 && 7 % -3 == 1 && 1 + 7 % 3 * 2 == 3 && context.sequenceNumber % 100 == 0 };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"sequenceNumber": 4200}`,
+			expectedResult: true,
+		},
+
+		{
+			name:      "long modulo by zero",
+			s:         `permit (principal, action, resource) when { 5 % 0 == 0 };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "division by zero: 5 % 0",
+		},
+
+		{
+			name:           "string concatenation",
+			s:              `permit (principal, action, resource) when { "Hello" + " " + "world" == "Hello world" && context.first + "." + context.last == "jane.doe" };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"first": "jane", "last": "doe"}`,
+			expectedResult: true,
+		},
+
+		{
+			name:      "string concatenation with long",
+			s:         `permit (principal, action, resource) when { 1 + "a" == "1a" };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       fmt.Sprintf("unknown token near comparitor or math operator: (%v)", polai.PLUS),
+		},
+
+		{
+			name:      "long concatenation with string",
+			s:         `permit (principal, action, resource) when { "a" + 1 == "a1" };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       fmt.Sprintf("unknown token near comparitor or math operator: (%v)", polai.PLUS),
+		},
+
+		{
+			name:           "string length",
+			s:              `permit (principal, action, resource) when { "".length() == 0 && "abc".length() == 3 && "héllo wörld".length() == 11 && "日本語".length() == 3 && context.token.length() > 0 && context.token.length() <= 256 };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"token": "abc123"}`,
+			expectedResult: true,
+		},
+
+		{
+			name:      "unknown string function",
+			s:         `permit (principal, action, resource) when { "abc".reverse() == "cba" };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "unknown string function: reverse",
+		},
+
+		{
+			name:           "string contains, startsWith and endsWith",
+			s:              `permit (principal, action, resource) when { "hello world".contains("o w") && !"hello".contains("z") && "abc".contains("") && "".contains("") && !"".contains("a") && "héllo wörld".contains("ö") && "日本語".startsWith("日本") && "日本語".endsWith("語") && context.path.startsWith("/api/") && context.path.endsWith(".json") && "abc".startsWith("") && !"".endsWith("a") && ["a", "b"].contains("a") };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"path": "/api/users.json"}`,
+			expectedResult: true,
+		},
+
+		{
+			name:      "string contains with non-string argument",
+			s:         `permit (principal, action, resource) when { "abc".contains(1) };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "string contains function requires a string argument",
+		},
+
+		{
+			name:      "string startsWith with non-string argument",
+			s:         `permit (principal, action, resource) when { "abc".startsWith(true) };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "startsWith function requires a string receiver and argument",
+		},
+
+		{
+			name:      "string endsWith on non-string",
+			s:         `permit (principal, action, resource) when { 123.endsWith("3") };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "endsWith function requires a string receiver and argument",
+		},
+
+		{
+			name:           "string case conversion",
+			s:              `permit (principal, action, resource) when { "Hello".toLowerCase() == "hello" && "abc".toUpperCase() == "ABC" && "ÉCOLE".toLowerCase() == "école" && context.s.toLowerCase() like "admin*" };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"s": "AdminUser"}`,
+			expectedResult: true,
+		},
+		{
+			name:      "string toLowerCase with argument",
+			s:         `permit (principal, action, resource) when { "Hello".toLowerCase(1) == "hello" };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "toLowerCase function expects 0 argument(s), got 1",
+		},
+
+		{
+			name:           "ip toIPv6",
+			s:              `permit (principal, action, resource) when { ip(context.v4).toIPv6() == ip("::ffff:10.0.0.1") && ip(context.v4).toIPv6().isIpv6() && ip(context.mapped).toIPv6() == ip("::ffff:10.0.0.1") && ip("10.0.0.0/24").toIPv6() == ip("::ffff:10.0.0.0/120") && ip("::1").toIPv6() == ip("::1") && ip("2001:db8::/32").toIPv6() == ip("2001:db8::/32") };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"v4": "10.0.0.1", "mapped": "::ffff:10.0.0.1"}`,
+			expectedResult: true,
+		},
+
+		{
+			name:           "ip prefixLength",
+			s:              `permit (principal, action, resource) when { ip("10.0.0.0/24").prefixLength() == 24 && ip("::1").prefixLength() == 128 && ip("10.0.0.1").prefixLength() == 32 && ip("2001:db8::/32").prefixLength() == 32 && ip("0.0.0.0/0").prefixLength() == 0 && ip(context.cidr).prefixLength() >= 16 };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"cidr": "192.168.0.0/16"}`,
+			expectedResult: true,
+		},
+
+		{
+			name:           "ip network",
+			s:              `permit (principal, action, resource) when { ip("10.0.1.5/24").network() == ip("10.0.1.0/24") && ip("10.0.1.5/24").network().prefixLength() == 24 && ip("2001:db8::1/64").network() == ip("2001:db8::/64") && ip("10.0.1.5").network() == ip("10.0.1.5") && ip(context.a).network() == ip(context.b).network() };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"a": "192.168.1.10/24", "b": "192.168.1.200/24"}`,
+			expectedResult: true,
+		},
+
+		{
+			name:      "ip network on invalid address",
+			s:         `permit (principal, action, resource) when { ip("10.0.1.500/24").network() == ip("10.0.1.0/24") };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "invalid ip",
+		},
+
+		{
+			name:      "network on non-ip",
+			s:         `permit (principal, action, resource) when { "10.0.1.5/24".network() == ip("10.0.1.0/24") };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "unknown string function: network",
+		},
+
+		{
+			name:      "ip method with argument",
+			s:         `permit (principal, action, resource) when { ip("127.0.0.1").isLoopback(5) };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "isLoopback function expects 0 argument(s), got 1",
+		},
+
+		{
+			name:           "decimal toLong",
+			s:              `permit (principal, action, resource) when { decimal("12.9").toLong() == 12 && decimal("-3.1").toLong() == -3 && decimal("0.9999").toLong() == 0 && decimal(context.amount).toLong() + 1 == 43 };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"amount": "42.5"}`,
+			expectedResult: true,
+		},
+
+		{
+			name:      "decimal toLong overflow",
+			s:         `permit (principal, action, resource) when { decimal("99999999999999999999.0").toLong() > 0 };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "integer overflow: decimal 99999999999999999999.0 out of range for long",
+		},
+
+		{
+			name:      "decimal toLong with argument",
+			s:         `permit (principal, action, resource) when { decimal("1.5").toLong(1) == 1 };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "toLong function expects 0 argument(s), got 1",
+		},
+
+		{
+			name:           "decimal from long",
+			s:              `permit (principal, action, resource) when { decimal(context.i).greaterThan(decimal("100")) && decimal(context.i).lessThan(decimal("123.0001")) && decimal(-5).lessThan(decimal("-4.9999")) && decimal(12).toLong() == 12 };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"i": 123}`,
+			expectedResult: true,
+		},
+
+		{
+			name:      "decimal from long out of range",
+			s:         `permit (principal, action, resource) when { decimal(context.i).greaterThan(decimal("100")) };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			context:   `{"i": 1000000000000000}`,
+			err:       "long 1000000000000000 out of range for decimal",
+		},
+
+		{
+			name:           "datetime comparison",
+			s:              `permit (principal, action, resource) when { datetime("2024-01-01T00:00:00Z").lessThan(datetime("2024-01-01T00:00:01Z")) && datetime(context.expiresAt).greaterThan(datetime("2024-06-01")) && datetime("2024-01-01T02:00:00+02:00") == datetime("2024-01-01T00:00:00Z") && datetime("2024-01-01").lessThanOrEqual(datetime("2024-01-01T00:00:00Z")) && datetime("2024-01-01").greaterThanOrEqual(datetime("2023-12-31T23:59:59.999Z")) && !datetime("2024-01-01").greaterThan(datetime("2024-01-01")) };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"expiresAt": "2024-12-31T23:59:59Z"}`,
+			expectedResult: true,
+		},
+
+		{
+			name:      "invalid datetime",
+			s:         `permit (principal, action, resource) when { datetime("yesterday").lessThan(datetime("2024-01-01")) };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "error parsing datetime",
+		},
+
+		{
+			name:      "datetime comparison with non-datetime",
+			s:         `permit (principal, action, resource) when { decimal("1.0").lessThan(datetime("2024-01-01")) };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "unexpected use of lessThan function",
+		},
+
+		{
+			name:           "duration arithmetic",
+			s:              `permit (principal, action, resource) when { duration("90s").toMilliseconds() == 90000 && duration("30m").toMilliseconds() == 1800000 && duration("-1h").toMilliseconds() == -3600000 && datetime("2024-01-01T00:00:00Z").addDuration(duration("24h")) == datetime("2024-01-02") && datetime("2024-01-01T00:00:00Z").subDuration(duration("30m")) == datetime("2023-12-31T23:30:00Z") && datetime("2024-01-01T00:00:00Z").addDuration(duration("-90s")) == datetime("2023-12-31T23:58:30Z") && datetime(context.createdAt).addDuration(duration("24h")).greaterThan(datetime("2024-03-01T12:00:00Z")) };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"createdAt": "2024-02-29T13:00:00Z"}`,
+			expectedResult: true,
+		},
+
+		{
+			name:      "invalid duration",
+			s:         `permit (principal, action, resource) when { duration("1 day").toMilliseconds() > 0 };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "error parsing duration",
+		},
+
+		{
+			name:      "duration toMilliseconds with argument",
+			s:         `permit (principal, action, resource) when { duration("1s").toMilliseconds(1) == 1000 };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "toMilliseconds function expects 0 argument(s), got 1",
+		},
+
+		{
+			name:      "duration added to non-datetime",
+			s:         `permit (principal, action, resource) when { "2024-01-01".addDuration(duration("1h")) == datetime("2024-01-01T01:00:00Z") };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "unexpected use of addDuration function",
+		},
+
+		{
+			name:           "set union",
+			s:              `permit (principal, action, resource) when { ["a", "b"].union(["b", "c"]).containsAll(["a", "b", "c"]) && !["a"].union(["b"]).contains("c") && ["a"].union(["b"]).containsAll(["a", "b"]) && [].union(["a"]).contains("a") && ["a"].union([]).contains("a") && principal.allowedRegions.union(resource.defaultRegions).contains(context.region) };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"region": "us-east-1"}`,
+			entities:       `[{"uid": "Principal::\"MyPrincipal\"", "attrs": {"allowedRegions": ["eu-west-1"]}}, {"uid": "Resource::\"MyResource\"", "attrs": {"defaultRegions": ["us-east-1", "eu-west-1"]}}]`,
+			expectedResult: true,
+		},
+
+		{
+			name:      "set union with non-set",
+			s:         `permit (principal, action, resource) when { ["a"].union("b").contains("b") };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "unexpected use of union function",
+		},
+
+		{
+			name:           "set intersection",
+			s:              `permit (principal, action, resource) when { ["a", "b"].intersection(["b", "a"]).containsAll(["a", "b"]) && ["a", "b"].intersection(["b", "c"]).contains("b") && !["a", "b"].intersection(["b", "c"]).containsAny(["a", "c"]) && !["a"].intersection(["b"]).contains("a") && ![].intersection(["a"]).contains("a") && !["a"].intersection([]).contains("a") };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+		{
+			name:           "set difference",
+			s:              `permit (principal, action, resource) when { !["a", "b"].difference(["b", "a"]).containsAny(["a", "b"]) && ["a", "b"].difference(["c"]).containsAll(["a", "b"]) && ["a", "b"].difference(["b"]).contains("a") && !["a", "b"].difference(["b"]).contains("b") && ![].difference(["a"]).contains("a") && ["a"].difference([]).contains("a") };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+		{
+			name:      "set difference with non-set",
+			s:         `permit (principal, action, resource) when { ["a"].difference(1).contains("a") };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "unexpected use of difference function",
+		},
+
+		{
+			name:           "set size",
+			s:              `permit (principal, action, resource) when { [].size() == 0 && ["a", "b", "c"].size() == 3 && principal.groups.size() > 0 };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			entities:       `[{"uid": "Principal::\"MyPrincipal\"", "attrs": {"groups": ["admins"]}}]`,
+			expectedResult: true,
+		},
+		{
+			name:           "set isEmpty",
+			s:              `permit (principal, action, resource) when { [].isEmpty() && !["a"].isEmpty() && !principal.groups.isEmpty() };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			entities:       `[{"uid": "Principal::\"MyPrincipal\"", "attrs": {"groups": ["admins"]}}]`,
+			expectedResult: true,
+		},
+		{
+			name:      "set size with argument",
+			s:         `permit (principal, action, resource) when { ["a"].size(1) == 1 };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "size function expects 0 argument(s), got 1",
+		},
+
+		{
+			name:           "record keys",
+			s:              `permit (principal, action, resource) when { context.headers.keys().contains("Authorization") && context.headers.keys().size() == 2 && {"a": 1, "b": {"c": 2}}.keys().containsAll(["a", "b"]) && !{"a": 1, "b": {"c": 2}}.keys().contains("c") && {}.keys().isEmpty() };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"headers": {"Authorization": "Bearer abc", "Accept": {"type": "json"}}}`,
+			expectedResult: true,
+		},
+		{
+			name:      "record keys with argument",
+			s:         `permit (principal, action, resource) when { {"a": 1}.keys("a").contains("a") };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "keys function expects 0 argument(s), got 1",
+		},
+
+		{
+			name:           "nested record has",
+			s:              `permit (principal, action, resource) when { (context has r) && (context.r has sub) && context.r.sub == "v" && !(context.r has other) };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"r": {"sub": "v"}}`,
+			expectedResult: true,
+		},
+		{
+			name:           "nested record has with missing intermediate",
+			s:              `permit (principal, action, resource) when { (context has r) && (context.r has sub) && context.r.sub == "v" };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"q": {"sub": "v"}}`,
+			expectedResult: false,
+		},
+
+		{
+			name:           "in set literal",
+			s:              `permit (principal, action, resource) when { principal in [User::"alice", User::"bob"] };`,
+			principal:      "User::\"bob\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+		{
+			name:           "in set literal no match",
+			s:              `permit (principal, action, resource) when { principal in [User::"alice", User::"bob"] };`,
+			principal:      "User::\"carol\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: false,
+		},
+		{
+			name:           "in set literal via parent",
+			s:              `permit (principal, action, resource) when { principal in [Group::"admins", Group::"ops"] };`,
+			principal:      "User::\"carol\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			entities:       `[{"uid": "User::\"carol\"", "parents": ["Group::\"ops\""]}, {"uid": "Group::\"ops\""}]`,
+			expectedResult: true,
+		},
+
+		{
+			name:           "set literal with expressions",
+			s:              `permit (principal, action, resource) when { [1+1, 2*3].contains(6) && [1+1, 2*3].contains(2) && ![1+1, 2*3].contains(1) && [principal].contains(principal) && [context.x, [1, 2]].contains(3) && [-1, 10 % 4].containsAll([-1, 2]) };`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"x": 3}`,
+			expectedResult: true,
+		},
+		{
+			name:      "set literal with erroring expression",
+			s:         `permit (principal, action, resource) when { [1, 1 / 0].contains(1) };`,
+			principal: "Principal::\"MyPrincipal\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			err:       "division by zero: 1 / 0",
+		},
+
+		{
+			name:           "entity set attribute",
+			s:              `permit (principal, action, resource) when { principal.tags.contains("admin") && !principal.tags.contains("guest") && principal.tags.containsAll(["admin", "dev"]) };`,
+			principal:      "User::\"alice\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			entities:       `[{"uid": "User::\"alice\"", "attrs": {"tags": ["admin", "dev"]}}]`,
+			expectedResult: true,
+		},
+		{
+			name:           "entity set attribute without match",
+			s:              `permit (principal, action, resource) when { principal.tags.contains("admin") };`,
+			principal:      "User::\"alice\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			entities:       `[{"uid": "User::\"alice\"", "attrs": {"tags": []}}]`,
+			expectedResult: false,
+		},
+
+		{
+			name:           "entity record attribute",
+			s:              `permit (principal, action, resource) when { principal.addr.city == "Seattle" && principal.addr.geo.zip == 98101 && principal.addr has geo && !(principal.addr.geo has country) };`,
+			principal:      "User::\"alice\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			entities:       `[{"uid": "User::\"alice\"", "attrs": {"addr": {"city": "Seattle", "geo": {"zip": 98101}}}}]`,
+			expectedResult: true,
+		},
+		{
+			name:      "entity record attribute missing key",
+			s:         `permit (principal, action, resource) when { principal.addr.country == "US" };`,
+			principal: "User::\"alice\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			entities:  `[{"uid": "User::\"alice\"", "attrs": {"addr": {"city": "Seattle"}}}]`,
+			err:       "attribute not set",
+		},
+
+		{
+			name:           "entity extension attribute",
+			s:              `permit (principal, action, resource) when { principal.loginIP.isIpv4() && principal.loginIP.isInRange(ip("10.0.0.0/8")) && principal.limit.greaterThan(decimal("1.5")) };`,
+			principal:      "User::\"alice\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			entities:       `[{"uid": "User::\"alice\"", "attrs": {"loginIP": {"__extn": {"fn": "ip", "arg": "10.0.0.1"}}, "limit": {"__extn": {"fn": "decimal", "arg": "2.25"}}}}]`,
+			expectedResult: true,
+		},
+		{
+			name:      "entity extension attribute with unknown function",
+			s:         `permit (principal, action, resource) when { principal.loginIP.isIpv4() };`,
+			principal: "User::\"alice\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			entities:  `[{"uid": "User::\"alice\"", "attrs": {"loginIP": {"__extn": {"fn": "mac", "arg": "00:00:00:00:00:00"}}}}]`,
+			err:       "unknown function: mac",
+		},
+		{
+			name:      "entity extension attribute with invalid argument",
+			s:         `permit (principal, action, resource) when { principal.loginIP.isIpv4() };`,
+			principal: "User::\"alice\"",
+			action:    "Action::\"MyAction\"",
+			resource:  "Resource::\"MyResource\"",
+			entities:  `[{"uid": "User::\"alice\"", "attrs": {"loginIP": {"__extn": {"fn": "ip", "arg": "10.0.0"}}}}]`,
+			err:       "invalid ip",
+		},
+
+		{
+			name:           "entity reference attribute chaining",
+			s:              `permit (principal, action, resource) when { principal.manager == User::"bob" && principal.manager.manager == User::"carol" && principal.manager.level == 2 && !(principal.manager.manager has level) };`,
+			principal:      "User::\"alice\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			entities:       `[{"uid": "User::\"alice\"", "attrs": {"manager": {"__entity": {"type": "User", "id": "bob"}}}}, {"uid": "User::\"bob\"", "attrs": {"level": 2, "manager": {"__entity": {"type": "User", "id": "carol"}}}}, {"uid": "User::\"carol\""}]`,
+			expectedResult: true,
+		},
+
+		{
+			name:           "principal in scope with parents listed after children",
+			s:              `permit (principal in Group::"root", action, resource);`,
+			principal:      "User::\"alice\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			entities:       `[{"uid": "User::\"alice\"", "parents": ["Group::\"child\""]}, {"uid": "Group::\"child\"", "parents": ["Group::\"root\""]}, {"uid": "Group::\"root\""}]`,
+			expectedResult: true,
+		},
+		{
+			name:           "principal in scope with parents listed before children",
+			s:              `permit (principal in Group::"root", action, resource);`,
+			principal:      "User::\"alice\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			entities:       `[{"uid": "Group::\"root\""}, {"uid": "Group::\"child\"", "parents": ["Group::\"root\""]}, {"uid": "User::\"alice\"", "parents": ["Group::\"child\""]}]`,
+			expectedResult: true,
+		},
+
+		{
+			name: "bare integer literals",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				1 + 2 == 3 && 007 == 7 && 10 > -10 && 0 == 0
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "or short-circuit preserves stack",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				(true || (context.missing.b + 1 > [1, 2].size() && {x: 1}.x == 1)) && context.d == 2
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"d": 2}`,
+			expectedResult: true,
+		},
+
+		{
+			name: "missing or operand",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				true ||
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: false,
+			err:            "invalid stack state",
+		},
+
+		{
+			name: "missing operand within parentheses",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				true || (false &&)
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: false,
+			err:            "invalid stack state",
+		},
+
+		{
+			name: "consecutive negations",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				!!true == true && !!!false == true && !!!!true && !(!!false)
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "too many consecutive negations",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				!!!!!true
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: false,
+			err:            "too many consecutive negations, at most 4 are allowed at line 7, column 9",
+		},
+
+		{
+			name: "method argument error",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				ip("10.0.0.1").isInRange(ip("10.0.0.0/abc"))
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: false,
+			err:            "invalid ip",
+		},
+
+		{
+			name: "method argument error within expression",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				true && [1, 2].contains(context.missing) || false
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: false,
+			err:            "attribute not set",
+		},
+
+		{
+			name: "keywords as attribute names and record keys",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				context.let == 1 && context.is == "a" &&
+				context has let && context has is &&
+				{let: 1, is: 2}.let == 1 && {"a": 1, is: 2}.is == 2 &&
+				principal is Principal
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			context:        `{"let": 1, "is": "a"}`,
+			expectedResult: true,
+		},
+
+		{
+			name: "escaped asterisk outside like patterns",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				"\*" == "*" && "\*".length() == 1 && "a\*b".contains("*") && ["\*"].contains("*")
+			};`,
+			principal:      "Principal::\"MyPrincipal\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "Resource::\"MyResource\"",
+			expectedResult: true,
+		},
+
+		{
+			name: "Errors",
+			s:    `foo`,
+			err:  `found "foo", expected permit or forbid at line 1, column 1`,
+		},
+	}
+
+	for i, tt := range tests {
+		e := polai.NewEvaluator(strings.NewReader(tt.s))
+		if tt.entities != "" {
+			e.SetEntities(strings.NewReader(tt.entities))
+		}
+		if tt.disableShortCircuiting {
+			e.AllowShortCircuiting = false
+		}
+		result, err := e.Evaluate(tt.principal, tt.action, tt.resource, tt.context)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s\n%q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.name, tt.s, tt.err, err)
+		} else if tt.err == "" && tt.expectedResult != result {
+			t.Errorf("%d. %s\n%q\n\result mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.name, tt.s, tt.expectedResult, result)
+		}
+	}
+}
+
+// Ensure the evaluator counts all matching policies.
+func TestEvaluator_CountMatchingPolicies(t *testing.T) {
+	var tests = []struct {
+		name            string
+		s               string
+		expectedPermits int
+		expectedForbids int
+		principal       string
+		action          string
+		resource        string
+		context         string
+		err             string
+	}{
+		{
+			name: "Shadowed permit",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			);
+			permit (
+				principal == Principal::"MyPrincipal",
+				action,
+				resource
+			);
+			forbid (
+				principal,
+				action,
+				resource
+			);`,
+			principal:       "Principal::\"MyPrincipal\"",
+			action:          "Action::\"MyAction\"",
+			resource:        "Resource::\"MyResource\"",
+			expectedPermits: 2,
+			expectedForbids: 1,
+		},
+
+		{
+			name: "Non-matching scope and conditions",
+			s: `
+			permit (
+				principal == Principal::"OtherPrincipal",
+				action,
+				resource
+			);
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				false
+			};
+			forbid (
+				principal,
+				action,
+				resource
+			) unless {
+				true
+			};`,
+			principal:       "Principal::\"MyPrincipal\"",
+			action:          "Action::\"MyAction\"",
+			resource:        "Resource::\"MyResource\"",
+			expectedPermits: 0,
+			expectedForbids: 0,
+		},
+
+		{
+			name: "Errors",
+			s:    `foo`,
+			err:  `found "foo", expected permit or forbid at line 1, column 1`,
+		},
+	}
+
+	for i, tt := range tests {
+		e := polai.NewEvaluator(strings.NewReader(tt.s))
+		permits, forbids, err := e.CountMatchingPolicies(tt.principal, tt.action, tt.resource, tt.context)
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s\n%q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.name, tt.s, tt.err, err)
+		} else if tt.err == "" && (tt.expectedPermits != permits || tt.expectedForbids != forbids) {
+			t.Errorf("%d. %s\n%q\n\ncount mismatch:\n\nexp=%d/%d\n\ngot=%d/%d\n\n", i, tt.name, tt.s, tt.expectedPermits, tt.expectedForbids, permits, forbids)
+		}
+	}
+}
+
+// Ensure the evaluator binds template slots.
+func TestEvaluator_BindSlots(t *testing.T) {
+	var tests = []struct {
+		name           string
+		s              string
+		slots          map[string]string
+		expectedResult bool
+		principal      string
+		action         string
+		resource       string
+		entities       string
+		err            string
+	}{
+		{
+			name: "Bound principal and resource",
+			s: `
+			permit (
+				principal == ?principal,
+				action,
+				resource in ?resource
+			);`,
+			slots: map[string]string{
+				"?principal": "User::\"alice\"",
+				"?resource":  "Folder::\"MyFolder\"",
+			},
+			principal:      "User::\"alice\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "File::\"MyFile\"",
+			entities:       `[{"uid": "File::\"MyFile\"", "parents": ["Folder::\"MyFolder\""]}]`,
+			expectedResult: true,
+		},
+
+		{
+			name: "Bound principal mismatch",
+			s: `
+			permit (
+				principal == ?principal,
+				action,
+				resource
+			);`,
+			slots: map[string]string{
+				"?principal": "User::\"alice\"",
+			},
+			principal:      "User::\"bob\"",
+			action:         "Action::\"MyAction\"",
+			resource:       "File::\"MyFile\"",
+			expectedResult: false,
+		},
+
+		{
+			name: "Missing slot",
+			s: `
+			permit (
+				principal == ?principal,
+				action,
+				resource in ?resource
+			);`,
+			slots: map[string]string{
+				"?principal": "User::\"alice\"",
+			},
+			err: "no value provided for template slot ?resource",
+		},
+
+		{
+			name: "Unknown slot",
+			s: `
+			permit (
+				principal == ?principal,
+				action,
+				resource
+			);`,
+			slots: map[string]string{
+				"?action": "Action::\"MyAction\"",
+			},
+			err: `unknown template slot "?action"`,
+		},
+
+		{
+			name: "Invalid entity",
+			s: `
+			permit (
+				principal == ?principal,
+				action,
+				resource
+			);`,
+			slots: map[string]string{
+				"?principal": "alice",
+			},
+			err: `invalid entity "alice" for template slot ?principal`,
+		},
+	}
+
+	for i, tt := range tests {
+		e := polai.NewEvaluator(strings.NewReader(tt.s))
+		if tt.entities != "" {
+			e.SetEntities(strings.NewReader(tt.entities))
+		}
+		bound, err := e.BindSlots(tt.slots)
+		var result bool
+		if err == nil {
+			result, err = bound.Evaluate(tt.principal, tt.action, tt.resource, "{}")
+		}
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s\n%q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.name, tt.s, tt.err, err)
+		} else if tt.err == "" && tt.expectedResult != result {
+			t.Errorf("%d. %s\n%q\n\nresult mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.name, tt.s, tt.expectedResult, result)
+		}
+	}
+}
+
+// Ensure the statements of a bound evaluator are retained when it is reset.
+func TestEvaluator_BindSlotsReset(t *testing.T) {
+	e := polai.NewEvaluatorFromString(`permit (principal == ?principal, action, resource);`)
+	bound, err := e.BindSlots(map[string]string{"?principal": `User::"alice"`})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := bound.Reset(); errstring(err) != "policy reader cannot be read again, use SetPolicyReader instead" {
+		t.Errorf("error mismatch: got=%v", err)
+	}
+	if result, err := bound.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`); err != nil || !result {
+		t.Errorf("result mismatch: exp=true got=%v (%v)", result, err)
+	}
+}
+
+// Ensure MustNewEvaluator parses the policy up front and panics on failure.
+func TestEvaluator_MustNewEvaluator(t *testing.T) {
+	e := polai.MustNewEvaluator(`permit (principal == User::"alice", action, resource);`)
+	for i := 0; i < 2; i++ {
+		result, err := e.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`)
+		if err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		}
+		if !result {
+			t.Errorf("%d. result mismatch: exp=true got=false", i)
+		}
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected panic for invalid policy")
+		} else if exp, got := `found "foo", expected permit or forbid at line 1, column 1`, fmt.Sprint(r); exp != got {
+			t.Errorf("panic mismatch:\n  exp=%s\n  got=%s", exp, got)
+		}
+	}()
+	polai.MustNewEvaluator(`foo`)
+}
+
+// Ensure evaluators may be created from policy files and strings.
+func TestEvaluator_NewEvaluatorFromFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy.cedar")
+	if err := os.WriteFile(path, []byte(`permit (principal == User::"alice", action, resource);`), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	e, err := polai.NewEvaluatorFromFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// the file is no longer needed once the evaluator has been created
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		result, err := e.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`)
+		if err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		}
+		if !result {
+			t.Errorf("%d. result mismatch: exp=true got=false", i)
+		}
+	}
+	// the closed file cannot be parsed again, so the statements are retained
+	if err := e.Reset(); errstring(err) != "policy reader cannot be read again, use SetPolicyReader instead" {
+		t.Errorf("error mismatch: got=%v", err)
+	}
+	if result, err := e.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`); err != nil || !result {
+		t.Errorf("result mismatch: exp=true got=%v (%v)", result, err)
+	}
+
+	if _, err := polai.NewEvaluatorFromFile(filepath.Join(dir, "missing.cedar")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("error mismatch: exp=%s got=%v", os.ErrNotExist, err)
+	}
+	invalidPath := filepath.Join(dir, "invalid.cedar")
+	if err := os.WriteFile(invalidPath, []byte(`foo`), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := polai.NewEvaluatorFromFile(invalidPath); errstring(err) != `found "foo", expected permit or forbid at line 1, column 1` {
+		t.Errorf("error mismatch: got=%v", err)
+	}
+
+	result, err := polai.NewEvaluatorFromString(`permit (principal, action, resource);`).Evaluate(`User::"bob"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !result {
+		t.Errorf("result mismatch: exp=true got=false")
+	}
+}
+
+// Ensure the parsed policy is cached across evaluations until the evaluator is reset.
+func TestEvaluator_PreParse(t *testing.T) {
+	policyReader := strings.NewReader(`permit (principal == User::"alice", action, resource);`)
+	e := polai.NewEvaluator(policyReader)
+	if err := e.PreParse(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		result, err := e.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`)
+		if err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		}
+		if !result {
+			t.Errorf("%d. result mismatch: exp=true got=false", i)
+		}
+	}
+
+	// without repositioning the reader, there is nothing left to parse after a reset
+	if err := e.Reset(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result, err := e.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`); err != nil || result {
+		t.Errorf("result mismatch: exp=false got=%v (%v)", result, err)
+	}
+
+	if _, err := policyReader.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := e.Reset(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result, err := e.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`); err != nil || !result {
+		t.Errorf("result mismatch: exp=true got=%v (%v)", result, err)
+	}
+
+	if err := polai.NewEvaluatorFromString(`foo`).PreParse(); errstring(err) != `found "foo", expected permit or forbid at line 1, column 1` {
+		t.Errorf("error mismatch: got=%v", err)
+	}
+}
+
+// Ensure the policy is only parsed once, without being parsed up front, across repeated evaluations.
+func TestEvaluator_RepeatedEvaluate(t *testing.T) {
+	e := polai.NewEvaluator(strings.NewReader(`
+	permit (principal == User::"alice", action, resource);
+	forbid (principal, action, resource) when { context.blocked };
+	`))
+
+	for i := 0; i < 3; i++ {
+		if result, err := e.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{"blocked": false}`); err != nil || !result {
+			t.Errorf("%d. result mismatch: exp=true got=%v (%v)", i, result, err)
+		}
+		if result, err := e.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{"blocked": true}`); err != nil || result {
+			t.Errorf("%d. result mismatch: exp=false got=%v (%v)", i, result, err)
+		}
+	}
+}
+
+// Ensure a policy which cannot be parsed fails every evaluation, not only the first.
+func TestEvaluator_RepeatedParseError(t *testing.T) {
+	e := polai.NewEvaluator(strings.NewReader(`permit (principal, action, resource) when { # };`))
+
+	for i := 0; i < 2; i++ {
+		result, err := e.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`)
+		if errstring(err) != `unexpected token found in condition clause "#" (ILLEGAL) at line 1, column 45` {
+			t.Errorf("%d. error mismatch: got=%v", i, err)
+		}
+		if result {
+			t.Errorf("%d. result mismatch: exp=false got=true", i)
+		}
+	}
+}
+
+// Ensure attribute access and has checks see entities added and removed after the entity index was built.
+func TestEvaluator_EntityIndexMutations(t *testing.T) {
+	e := polai.MustNewEvaluator(`permit (principal, action, resource) when { principal has level && principal.level > 1 };`)
+	e.SetEntities(strings.NewReader(`[{"uid": "User::\"alice\"", "attrs": {"level": 2}}]`))
+	if err := e.BuildEntityIndex(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	level := int64(3)
+	for i, step := range []struct {
+		mutate func() error
+		exp    bool
+	}{
+		{mutate: func() error { return nil }, exp: false},
+		{mutate: func() error {
+			return e.AddEntity(polai.Entity{Identifier: `User::"bob"`, Attributes: []polai.Attribute{{Name: "level", LongValue: &level}}})
+		}, exp: true},
+		{mutate: func() error { return e.RemoveEntity(`User::"bob"`) }, exp: false},
+	} {
+		if err := step.mutate(); err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		}
+		result, err := e.Evaluate(`User::"bob"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`)
+		if err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		}
+		if result != step.exp {
+			t.Errorf("%d. result mismatch: exp=%v got=%v", i, step.exp, result)
+		}
+	}
+}
+
+// Ensure the policy may be replaced while the entity store is preserved.
+func TestEvaluator_SetPolicyReader(t *testing.T) {
+	e := polai.MustNewEvaluator(`permit (principal in Group::"admins", action, resource);`)
+	e.SetEntities(strings.NewReader(`[
+		{"uid": "User::\"alice\"", "attrs": {"level": 5}, "parents": ["Group::\"admins\""]}
+	]`))
+	if result, err := e.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`); err != nil || !result {
+		t.Fatalf("result mismatch: exp=true got=%v (%v)", result, err)
+	}
+
+	e.SetPolicyReader(strings.NewReader(`permit (principal, action, resource) when { principal.level > 3 };`))
+	if result, err := e.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`); err != nil || !result {
+		t.Errorf("result mismatch: exp=true got=%v (%v)", result, err)
+	}
+
+	e.SetPolicyReader(strings.NewReader(`forbid (principal in Group::"admins", action, resource);`))
+	if result, err := e.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`); err != nil || result {
+		t.Errorf("result mismatch: exp=false got=%v (%v)", result, err)
+	}
+}
+
+// Ensure all matching statements are returned in declaration order.
+func TestEvaluator_EvaluateAll(t *testing.T) {
+	e := polai.MustNewEvaluator(`
+	@id("first")
+	permit (principal, action, resource);
+	@id("second")
+	forbid (principal == User::"alice", action, resource);
+	@id("third")
+	permit (principal, action, resource) when { context.level > 3 };
+	@id("fourth")
+	permit (principal, action == Action::"view", resource);
+	`)
+
+	var tests = []struct {
+		principal string
+		action    string
+		context   string
+		expected  []string
+		err       string
+	}{
+		{principal: `User::"alice"`, action: `Action::"view"`, context: `{"level": 5}`, expected: []string{"first", "second", "third", "fourth"}},
+		{principal: `User::"alice"`, action: `Action::"view"`, context: `{"level": 1}`, expected: []string{"first", "second", "fourth"}},
+		{principal: `User::"bob"`, action: `Action::"edit"`, context: `{"level": 1}`, expected: []string{"first"}},
+		{principal: `User::"bob"`, action: `Action::"edit"`, context: `{}`, err: "attribute not set"},
+	}
+
+	for i, tt := range tests {
+		matches, err := e.EvaluateAll(tt.principal, tt.action, `Resource::"MyResource"`, tt.context)
+		if tt.err != errstring(err) {
+			t.Errorf("%d. error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.err, err)
+			continue
+		}
+		ids := []string{}
+		for _, stmt := range matches {
+			ids = append(ids, stmt.Annotations["id"])
+		}
+		if tt.err == "" && !reflect.DeepEqual(tt.expected, ids) {
+			t.Errorf("%d. match mismatch:\n  exp=%v\n  got=%v\n\n", i, tt.expected, ids)
+		}
+	}
+}
+
+// Ensure the statement which decided a request is returned.
+func TestEvaluator_GetMatchingPolicy(t *testing.T) {
+	e := polai.MustNewEvaluator(`
+	@id("viewers")
+	permit (principal, action == Action::"view", resource);
+	@id("blocked")
+	forbid (principal == User::"mallory", action, resource);
+	@id("levels")
+	permit (principal, action, resource) when { context.level > 3 };
+	`)
+
+	var tests = []struct {
+		principal string
+		action    string
+		context   string
+		index     int
+		id        string
+		permitted bool
+		err       string
+	}{
+		{principal: `User::"alice"`, action: `Action::"view"`, context: `{"level": 1}`, index: 0, id: "viewers", permitted: true},
+		{principal: `User::"mallory"`, action: `Action::"view"`, context: `{"level": 5}`, index: 1, id: "blocked", permitted: false},
+		{principal: `User::"alice"`, action: `Action::"edit"`, context: `{"level": 5}`, index: 2, id: "levels", permitted: true},
+		{principal: `User::"alice"`, action: `Action::"edit"`, context: `{"level": 1}`, index: -1},
+		{principal: `User::"alice"`, action: `Action::"edit"`, context: `{}`, index: -1, err: "attribute not set"},
+	}
+
+	for i, tt := range tests {
+		index, stmt, permitted, err := e.GetMatchingPolicy(tt.principal, tt.action, `Resource::"MyResource"`, tt.context)
+		if tt.err != errstring(err) {
+			t.Errorf("%d. error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.err, err)
+		}
+		if index != tt.index || permitted != tt.permitted {
+			t.Errorf("%d. result mismatch: exp=%d,%v got=%d,%v", i, tt.index, tt.permitted, index, permitted)
+		}
+		if tt.id == "" {
+			if stmt != nil {
+				t.Errorf("%d. expected no statement, got %v", i, stmt)
+			}
+		} else if stmt == nil || stmt.Annotations["id"] != tt.id {
+			t.Errorf("%d. statement mismatch: exp=%s got=%v", i, tt.id, stmt)
+		}
+	}
+}
+
+// Ensure the complexity limit of like pattern matches may be raised or removed.
+func TestEvaluator_LikeComplexityLimit(t *testing.T) {
+	e := polai.MustNewEvaluator(`permit (principal, action, resource) when { !("` + strings.Repeat("a", 30) + `" like "*a*a*a*a*a*b*") };`)
+
+	if _, err := e.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`); errstring(err) != "string match too complex" {
+		t.Errorf("error mismatch: exp=string match too complex got=%v", err)
+	}
+
+	for _, limit := range []int{100000, 0, -1} {
+		e.LikeComplexityLimit = limit
+		result, err := e.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`)
+		if err != nil {
+			t.Fatalf("%d. unexpected error: %s", limit, err)
+		}
+		if !result {
+			t.Errorf("%d. result mismatch: exp=true got=false", limit)
+		}
+	}
+}
+
+// Ensure disabling short-circuiting only changes whether errors in skipped operands are reported.
+func TestEvaluator_ShortCircuitingParity(t *testing.T) {
+	entities := `[
+		{"uid": "User::\"alice\"", "attrs": {"level": 5}, "parents": ["Group::\"admins\""]},
+		{"uid": "Action::\"view\"", "parents": ["Action::\"read\""]},
+		{"uid": "Photo::\"a.jpg\"", "parents": ["Album::\"trip\""]}
+	]`
+
+	var tests = []struct {
+		name                   string
+		s                      string
+		expectedResult         bool
+		err                    string
+		errWithoutShortCircuit string
+	}{
+		{name: "scope match", s: `permit (principal in Group::"admins", action in [Action::"edit", Action::"read"], resource in Album::"trip");`, expectedResult: true},
+		{name: "principal mismatch", s: `permit (principal == User::"bob", action in [Action::"edit", Action::"read"], resource);`, expectedResult: false},
+		{name: "action mismatch", s: `permit (principal, action in [Action::"edit", Action::"delete"], resource in Album::"trip");`, expectedResult: false},
+		{name: "resource mismatch", s: `permit (principal in Group::"admins", action, resource is Album);`, expectedResult: false},
+		{name: "scope mismatch skips erroring condition", s: `permit (principal == User::"bob", action, resource) when { principal.missing };`, expectedResult: false},
+		{name: "scope mismatch skips erroring action", s: `permit (principal == User::"bob", action == Other::"view", resource);`, expectedResult: false},
+		{name: "scope match and condition", s: `permit (principal in Group::"admins", action, resource) when { principal.level > 3 || principal.level < 0 };`, expectedResult: true},
+		{name: "scope match and failed condition", s: `permit (principal in Group::"admins", action, resource) when { principal.level > 3 && context.urgent };`, expectedResult: false},
+		{name: "unless", s: `permit (principal, action, resource in Album::"trip") unless { principal.level < 3 && principal.level > 0 };`, expectedResult: true},
+		{name: "forbid decides before erroring permit", s: `forbid (principal, action, resource); permit (principal, action, resource) when { principal.missing };`, expectedResult: false},
+		{name: "permit decides before erroring permit", s: `permit (principal, action, resource); permit (principal, action, resource) when { principal.missing };`, expectedResult: true},
+		{name: "erroring forbid", s: `permit (principal, action, resource); forbid (principal, action, resource) when { principal.missing };`, err: "attribute not set"},
+		{name: "skipped and operand", s: `permit (principal, action, resource) when { principal.level < 3 && principal.missing };`, expectedResult: false, errWithoutShortCircuit: "attribute not set"},
+		{name: "skipped or operand", s: `permit (principal, action, resource) when { principal.level > 3 || principal.missing };`, expectedResult: true, errWithoutShortCircuit: "attribute not set"},
+		{name: "skipped if branch", s: `permit (principal, action, resource) when { if principal.level > 3 then true else principal.missing };`, expectedResult: true, errWithoutShortCircuit: "attribute not set"},
+	}
+
+	for i, tt := range tests {
+		for _, allowShortCircuiting := range []bool{true, false} {
+			e := polai.NewEvaluatorFromString(tt.s)
+			e.SetEntities(strings.NewReader(entities))
+			e.AllowShortCircuiting = allowShortCircuiting
+
+			expectedErr := tt.err
+			if !allowShortCircuiting && tt.errWithoutShortCircuit != "" {
+				expectedErr = tt.errWithoutShortCircuit
+			}
+			result, err := e.Evaluate(`User::"alice"`, `Action::"view"`, `Photo::"a.jpg"`, `{"urgent": false}`)
+			if expectedErr != errstring(err) {
+				t.Errorf("%d. %s (short-circuiting %v): error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.name, allowShortCircuiting, expectedErr, err)
+			} else if expectedErr == "" && tt.expectedResult != result {
+				t.Errorf("%d. %s (short-circuiting %v): result mismatch: exp=%v got=%v", i, tt.name, allowShortCircuiting, tt.expectedResult, result)
+			}
+		}
+	}
+}
+
+// Ensure the maximum precision of decimals may be changed or removed.
+func TestEvaluator_DecimalPrecision(t *testing.T) {
+	var tests = []struct {
+		precision int
+		s         string
+		err       string
+	}{
+		{precision: 4, s: `decimal("1.1234") == decimal("1.12340")`, err: "too much precision in decimal"},
+		{precision: 4, s: `decimal("1.12345").greaterThan(decimal("1.1234"))`, err: "too much precision in decimal"},
+		{precision: 6, s: `decimal("1.12345").greaterThan(decimal("1.1234")) && decimal("1.5") == decimal("1.50000")`},
+		{precision: 6, s: `decimal("1.1234567").greaterThan(decimal("1.1234"))`, err: "too much precision in decimal"},
+		{precision: 0, s: `decimal("1.123456789").lessThan(decimal("1.12345679")) && decimal(2) == decimal("2.000000")`},
+		{precision: -1, s: `decimal("1.123456789").lessThan(decimal("1.12345679"))`},
+	}
+
+	for i, tt := range tests {
+		e := polai.NewEvaluatorFromString(`permit (principal, action, resource) when { ` + tt.s + ` };`)
+		e.DecimalPrecision = tt.precision
+		result, err := e.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`)
+		if tt.err != errstring(err) {
+			t.Errorf("%d. error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.err, err)
+		} else if tt.err == "" && !result {
+			t.Errorf("%d. result mismatch: exp=true got=false", i)
+		}
+	}
+}
+
+// Ensure an evaluator returned by BindSlots uses its own decimal precision rather than that of its parent.
+func TestEvaluator_DecimalPrecisionBindSlots(t *testing.T) {
+	e := polai.NewEvaluatorFromString(`permit (principal == ?principal, action, resource) when { decimal("1.12345").greaterThan(decimal("1.1234")) };`)
+	bound, err := e.BindSlots(map[string]string{"?principal": `User::"alice"`})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	bound.DecimalPrecision = 6
+	if result, err := bound.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`); err != nil || !result {
+		t.Errorf("result mismatch: exp=true got=%v (%v)", result, err)
+	}
+
+	bound.DecimalPrecision = 4
+	e.DecimalPrecision = 0
+	if _, err := bound.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`); errstring(err) != "too much precision in decimal" {
+		t.Errorf("error mismatch: exp=too much precision in decimal got=%v", err)
+	}
+}
+
+// Ensure the evaluator enforces the maximum recursion depth.
+func TestEvaluator_MaxRecursionDepth(t *testing.T) {
+	var tests = []struct {
+		name           string
+		s              string
+		opts           []polai.Option
+		expectedResult bool
+		err            string
+	}{
+		{
+			name: "Default depth",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				{xyz: true}.xyz
+			};`,
+			expectedResult: true,
+		},
+
+		{
+			name: "Depth exceeded",
+			s: `
+			permit (
+				principal,
+				action,
+				resource
+			) when {
+				{xyz: true}.xyz
+			};`,
+			opts: []polai.Option{polai.WithMaxRecursionDepth(0)},
+			err:  "error whilst evaluating record value: maximum recursion depth of 0 exceeded",
+		},
+	}
+
+	for i, tt := range tests {
+		e := polai.NewEvaluator(strings.NewReader(tt.s), tt.opts...)
+		result, err := e.Evaluate("Principal::\"MyPrincipal\"", "Action::\"MyAction\"", "Resource::\"MyResource\"", "{}")
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %s\n%q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.name, tt.s, tt.err, err)
+		} else if tt.err == "" && tt.expectedResult != result {
+			t.Errorf("%d. %s\n%q\n\nresult mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.name, tt.s, tt.expectedResult, result)
+		}
+	}
+}
+
+// Ensure evaluation stops once the context is done.
+func TestEvaluator_EvaluateWithContext(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&sb, "permit (principal, action, resource) when { context.n > %d && context.s like \"*x*\" };\n", i)
+	}
+	e := polai.NewEvaluatorFromString(sb.String())
+	if err := e.WarmUp(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	result, err := e.EvaluateWithContext(ctx, "Principal::\"MyPrincipal\"", "Action::\"MyAction\"", "Resource::\"MyResource\"", `{"n": 0, "s": "abc"}`)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error mismatch: exp=%s got=%v", context.DeadlineExceeded, err)
+	}
+	if result {
+		t.Errorf("result mismatch: exp=false got=true")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := e.EvaluateWithContext(ctx, "Principal::\"MyPrincipal\"", "Action::\"MyAction\"", "Resource::\"MyResource\"", `{"n": 1, "s": "x"}`); !errors.Is(err, context.Canceled) {
+		t.Errorf("error mismatch: exp=%s got=%v", context.Canceled, err)
+	}
+
+	result, err = e.EvaluateWithContext(context.Background(), "Principal::\"MyPrincipal\"", "Action::\"MyAction\"", "Resource::\"MyResource\"", `{"n": 1, "s": "x"}`)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	} else if !result {
+		t.Errorf("result mismatch: exp=true got=false")
+	}
+}
+
+const batchTestPolicy = `
+permit (
+	principal in Group::"admins",
+	action,
+	resource
+) when {
+	if context.level > 2 then true else resource.public
+};
+forbid (
+	principal == User::"mallory",
+	action,
+	resource
+);`
+
+const batchTestEntities = `
+[
+	{
+		"uid": "User::\"alice\"",
+		"parents": ["Group::\"admins\""]
+	},
+	{
+		"uid": "Photo::\"public\"",
+		"attrs": {
+			"public": true
+		}
+	}
+]`
+
+func batchTestRequests(n int) ([]polai.EvaluationRequest, []polai.EvaluationResult) {
+	var requests []polai.EvaluationRequest
+	var expected []polai.EvaluationResult
+	for i := 0; i < n; i++ {
+		switch i % 4 {
+		case 0:
+			requests = append(requests, polai.EvaluationRequest{Principal: `User::"alice"`, Action: `Action::"view"`, Resource: `Photo::"private"`, Context: `{"level": 3}`})
+			expected = append(expected, polai.EvaluationResult{Permitted: true, Effect: polai.PERMIT, PolicyIndex: 0})
+		case 1:
+			requests = append(requests, polai.EvaluationRequest{Principal: `User::"alice"`, Action: `Action::"view"`, Resource: `Photo::"public"`, Context: `{"level": 1}`})
+			expected = append(expected, polai.EvaluationResult{Permitted: true, Effect: polai.PERMIT, PolicyIndex: 0})
+		case 2:
+			requests = append(requests, polai.EvaluationRequest{Principal: `User::"bob"`, Action: `Action::"view"`, Resource: `Photo::"public"`, Context: `{"level": 3}`})
+			expected = append(expected, polai.EvaluationResult{Permitted: false, Effect: polai.ILLEGAL, PolicyIndex: -1})
+		case 3:
+			requests = append(requests, polai.EvaluationRequest{Principal: `User::"mallory"`, Action: `Action::"view"`, Resource: `Photo::"public"`, Context: `{"level": 3}`})
+			expected = append(expected, polai.EvaluationResult{Permitted: false, Effect: polai.FORBID, PolicyIndex: 1})
+		}
+	}
+
+	return requests, expected
+}
+
+// Ensure batches of requests are evaluated in order, serially and in parallel.
+func TestEvaluator_EvaluateBatch(t *testing.T) {
+	requests, expected := batchTestRequests(200)
+	requests = append(requests, polai.EvaluationRequest{Principal: `User::"alice"`, Action: `Action::"view"`, Resource: `Photo::"private"`, Context: `{"level": 1}`})
+
+	for _, concurrency := range []int{0, 1, 4} {
+		e := polai.NewEvaluatorFromString(batchTestPolicy)
+		e.SetEntities(strings.NewReader(batchTestEntities))
+		e.Concurrency = concurrency
+
+		results, err := e.EvaluateBatch(requests)
+		if err != nil {
+			t.Fatalf("concurrency %d: unexpected error: %s", concurrency, err)
+		}
+		if len(results) != len(requests) {
+			t.Fatalf("concurrency %d: result count mismatch: exp=%d got=%d", concurrency, len(requests), len(results))
+		}
+		if !reflect.DeepEqual(expected, results[:len(expected)]) {
+			t.Errorf("concurrency %d: result mismatch:\n  exp=%v\n  got=%v", concurrency, expected, results[:len(expected)])
+		}
+		if last := results[len(results)-1]; last.Permitted || errstring(last.Err) != "attribute not set" {
+			t.Errorf("concurrency %d: expected attribute error, got %v", concurrency, last)
+		}
+	}
+
+	if _, err := polai.NewEvaluatorFromString(`foo`).EvaluateBatch(requests); errstring(err) != `found "foo", expected permit or forbid at line 1, column 1` {
+		t.Errorf("error mismatch: got=%v", err)
+	}
+}
+
+func benchmarkEvaluateBatch(b *testing.B, concurrency int) {
+	requests, _ := batchTestRequests(1000)
+	e := polai.NewEvaluatorFromString(batchTestPolicy)
+	e.SetEntities(strings.NewReader(batchTestEntities))
+	e.Concurrency = concurrency
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := e.EvaluateBatch(requests); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEvaluateBatch_Serial(b *testing.B)   { benchmarkEvaluateBatch(b, 1) }
+func BenchmarkEvaluateBatch_Parallel(b *testing.B) { benchmarkEvaluateBatch(b, 8) }
+
+// benchmarkEvaluate measures a single evaluation, either parsing the policy for each request or reusing the
+// statements cached by WarmUp.
+func benchmarkEvaluate(b *testing.B, preParse bool) {
+	e := polai.NewEvaluatorFromString(batchTestPolicy)
+	if err := e.WarmUp(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !preParse {
+			e = polai.NewEvaluatorFromString(batchTestPolicy)
+		}
+		if _, err := e.Evaluate(`User::"alice"`, `Action::"view"`, `Photo::"public"`, `{"level": 3}`); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEvaluate_Parse(b *testing.B)     { benchmarkEvaluate(b, false) }
+func BenchmarkEvaluate_PreParsed(b *testing.B) { benchmarkEvaluate(b, true) }
+
+// Ensure explained evaluations report the deciding statement and each evaluated condition clause.
+func TestEvaluator_EvaluateExplained(t *testing.T) {
+	e := polai.MustNewEvaluator(`
+	forbid (principal, action, resource) when { context.blocked };
+	permit (principal, action == Action::"edit", resource);
+	permit (principal, action, resource) when { context.level > 1 } unless { context.readOnly } when { context.ssl };
+	permit (principal, action, resource) when { true } unless { false };
+	`)
+
+	result, err := e.EvaluateExplained(`User::"alice"`, `Action::"view"`, `Photo::"a.jpg"`, `{"blocked": false, "level": 2, "readOnly": false, "ssl": false}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	exp := polai.EvaluationResult{
+		Permitted:   true,
+		Effect:      polai.PERMIT,
+		PolicyIndex: 3,
+		Conditions: []polai.ConditionResult{
+			{PolicyIndex: 0, ClauseType: polai.WHEN, Outcome: false},
+			{PolicyIndex: 2, ClauseType: polai.WHEN, Outcome: true},
+			{PolicyIndex: 2, ClauseType: polai.UNLESS, Outcome: false},
+			{PolicyIndex: 2, ClauseType: polai.WHEN, Outcome: false},
+			{PolicyIndex: 3, ClauseType: polai.WHEN, Outcome: true},
+			{PolicyIndex: 3, ClauseType: polai.UNLESS, Outcome: false},
+		},
+	}
+	if !reflect.DeepEqual(exp, result) {
+		t.Errorf("result mismatch:\n  exp=%+v\n  got=%+v", exp, result)
+	}
+
+	result, err = e.EvaluateExplained(`User::"alice"`, `Action::"view"`, `Photo::"a.jpg"`, `{"blocked": true}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result.Permitted || result.Effect != polai.FORBID || result.PolicyIndex != 0 || len(result.Conditions) != 1 {
+		t.Errorf("expected explicit forbid by statement 0, got %+v", result)
+	}
+
+	result, err = e.EvaluateExplained(`User::"alice"`, `Action::"view"`, `Photo::"a.jpg"`, `{"blocked": false, "level": 2}`)
+	if errstring(err) != "attribute not set" {
+		t.Errorf("error mismatch: exp=attribute not set got=%v", err)
+	}
+	if last := result.Conditions[len(result.Conditions)-1]; last.PolicyIndex != 2 || last.ClauseType != polai.UNLESS || errstring(last.Error) != "attribute not set" {
+		t.Errorf("expected failing unless clause of statement 2, got %+v", last)
+	}
+
+	result, err = polai.NewEvaluatorFromString(`permit (principal, action, resource) when { false };`).EvaluateExplained(`User::"alice"`, `Action::"view"`, `Photo::"a.jpg"`, `{}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result.Permitted || result.Effect != polai.ILLEGAL || result.PolicyIndex != -1 {
+		t.Errorf("expected implicit deny, got %+v", result)
+	}
+}
+
+// Ensure requests can be evaluated using the request struct.
+func TestEvaluator_EvaluateRequest(t *testing.T) {
+	var tests = []struct {
+		name string
+		req  polai.EvaluationRequest
+		exp  polai.EvaluationResult
+		err  string
+	}{
+		{
+			name: "permitted",
+			req:  polai.EvaluationRequest{Principal: `User::"alice"`, Action: `Action::"view"`, Resource: `Photo::"a.jpg"`, Context: `{"ssl": true}`},
+			exp:  polai.EvaluationResult{Permitted: true, Effect: polai.PERMIT, PolicyIndex: 1},
+		},
+		{
+			name: "forbidden",
+			req:  polai.EvaluationRequest{Principal: `User::"mallory"`, Action: `Action::"view"`, Resource: `Photo::"a.jpg"`, Context: `{"ssl": true}`},
+			exp:  polai.EvaluationResult{Permitted: false, Effect: polai.FORBID, PolicyIndex: 0},
+		},
+		{
+			name: "empty context",
+			req:  polai.EvaluationRequest{Principal: `User::"alice"`, Action: `Action::"view"`, Resource: `Photo::"a.jpg"`},
+			exp:  polai.EvaluationResult{Permitted: false, Effect: polai.ILLEGAL, PolicyIndex: -1},
+		},
+		{
+			name: "error",
+			req:  polai.EvaluationRequest{Principal: `User::"alice"`, Action: `Action::"view"`, Resource: `Photo::"a.jpg"`, Context: `{"ssl": 1}`},
+			exp:  polai.EvaluationResult{Permitted: false, PolicyIndex: -1},
+			err:  fmt.Sprintf("unknown token near and: (%v)", polai.AND),
+		},
+	}
+
+	e := polai.MustNewEvaluator(`
+	forbid (principal == User::"mallory", action, resource);
+	permit (principal, action, resource) when { context has ssl && context.ssl };`)
+
+	for i, tt := range tests {
+		result := e.EvaluateRequest(tt.req)
+		if errstring(result.Err) != tt.err {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%v", i, tt.name, tt.err, result.Err)
+		}
+		result.Err = nil
+		if !reflect.DeepEqual(tt.exp, result) {
+			t.Errorf("%d. %s: result mismatch:\n  exp=%+v\n  got=%+v", i, tt.name, tt.exp, result)
+		}
+	}
+}
+
+// Ensure evaluation errors can be extracted as typed errors.
+func TestEvaluator_TypedErrors(t *testing.T) {
+	evaluate := func(policy, entities, context string) error {
+		e := polai.NewEvaluator(strings.NewReader(policy))
+		if entities != "" {
+			e.SetEntities(strings.NewReader(entities))
+		}
+		_, err := e.Evaluate(`User::"alice"`, `Action::"view"`, `Photo::"a.jpg"`, context)
+		return err
+	}
+
+	err := evaluate(`permit (principal, action, resource) when { context.a };`, "", `{"a": 1}`)
+	var evalErr *polai.EvalError
+	if !errors.As(err, &evalErr) {
+		t.Fatalf("expected EvalError, got %#v", err)
+	}
+	if evalErr.Msg != "condition return is not boolean" || evalErr.Expr != "context.a" {
+		t.Errorf("unexpected EvalError: %+v", evalErr)
+	}
+
+	err = evaluate(`permit (principal, action, resource) when { context.missing == 1 };`, "", `{}`)
+	var attrErr *polai.AttributeError
+	if !errors.As(err, &attrErr) {
+		t.Fatalf("expected AttributeError, got %#v", err)
+	}
+	if attrErr.Entity != "" || attrErr.Attribute != "missing" || attrErr.Msg != "attribute not set" {
+		t.Errorf("unexpected AttributeError: %+v", attrErr)
+	}
+
+	err = evaluate(`permit (principal, action, resource) when { principal.missing == 1 };`, `[{"uid": "User::\"alice\"", "parents": []}]`, `{}`)
+	attrErr = nil
+	if !errors.As(err, &attrErr) {
+		t.Fatalf("expected AttributeError, got %#v", err)
+	}
+	if attrErr.Entity != `User::"alice"` || attrErr.Attribute != "missing" {
+		t.Errorf("unexpected AttributeError: %+v", attrErr)
+	}
+
+	err = evaluate(`permit (principal, action, resource) when { principal.missing == 1 };`, `{`, `{}`)
+	var entityErr *polai.EntityError
+	if !errors.As(err, &entityErr) {
+		t.Fatalf("expected EntityError, got %#v", err)
+	}
+	if !strings.HasPrefix(entityErr.Msg, "error parsing entity store json") {
+		t.Errorf("unexpected EntityError: %+v", entityErr)
+	}
+
+	_, err = polai.NewEvaluator(strings.NewReader(`permit (principal == ?principal, action, resource);`)).BindSlots(map[string]string{"?principal": "alice"})
+	entityErr = nil
+	if !errors.As(err, &entityErr) {
+		t.Fatalf("expected EntityError, got %#v", err)
+	}
+	if entityErr.Identifier != "alice" {
+		t.Errorf("unexpected EntityError: %+v", entityErr)
+	}
+}
+
+// Ensure now() returns the time from the configured clock.
+func TestEvaluator_Now(t *testing.T) {
+	clock := func() time.Time {
+		return time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	}
+	e := polai.NewEvaluatorFromString(`
+	permit (principal, action, resource) when {
+		datetime(context.expiresAt).greaterThan(now()) && now().greaterThanOrEqual(datetime("2024-06-01T12:00:00Z"))
+	};`, polai.WithClock(clock))
+	if err := e.WarmUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		expiresAt string
+		exp       bool
+	}{
+		{expiresAt: "2024-06-01T12:00:01Z", exp: true},
+		{expiresAt: "2024-06-01T12:00:00Z", exp: false},
+		{expiresAt: "2024-05-31T00:00:00Z", exp: false},
+	} {
+		result, err := e.Evaluate(`User::"alice"`, `Action::"view"`, `Photo::"a.jpg"`, fmt.Sprintf(`{"expiresAt": %q}`, tt.expiresAt))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.expiresAt, err)
+		} else if result != tt.exp {
+			t.Errorf("%s: result mismatch: exp=%v got=%v", tt.expiresAt, tt.exp, result)
+		}
+	}
+}
+
+// Ensure extension constructors and methods can be registered on an evaluator.
+func TestEvaluator_RegisterExtension(t *testing.T) {
+	var tests = []struct {
+		name string
+		s    string
+		exp  bool
+		err  string
+	}{
+		{
+			name: "constructor",
+			s:    `permit (principal, action, resource) when { uuid(context.id) == uuid("7C9E6679-7425-40DE-944B-E07FC1F90AE7") };`,
+			exp:  true,
+		},
+		{
+			name: "constructor error",
+			s:    `permit (principal, action, resource) when { uuid("abc") == "abc" };`,
+			err:  "invalid uuid",
+		},
+		{
+			name: "method",
+			s:    `permit (principal, action, resource) when { "hello".base64() == "aGVsbG8=" };`,
+			exp:  true,
+		},
+		{
+			name: "method with multiple arguments",
+			s:    `permit (principal, action, resource) when { "b".between("a", "c") && !"d".between("a", context.id) };`,
+			exp:  true,
+		},
+		{
+			name: "method with wrong number of arguments",
+			s:    `permit (principal, action, resource) when { "b".between("a") };`,
+			err:  "between function expects 2 argument(s), got 1",
+		},
+		{
+			name: "method on wrong type",
+			s:    `permit (principal, action, resource) when { 1.base64() == "MQ==" };`,
+			err:  "unexpected use of base64 function",
+		},
+		{
+			name: "unknown constructor",
+			s:    `permit (principal, action, resource) when { base64("abc") == "abc" };`,
+			err:  "unknown function: base64",
+		},
+	}
+
+	for i, tt := range tests {
+		e := polai.NewEvaluator(strings.NewReader(tt.s))
+		e.RegisterExtensionConstructor("uuid", func(arg polai.SequenceItem) (polai.SequenceItem, error) {
+			if arg.Token != polai.DBLQUOTESTR || len(arg.Normalized) != 36 {
+				return polai.SequenceItem{}, fmt.Errorf("invalid uuid")
+			}
+			return polai.SequenceItem{Token: polai.DBLQUOTESTR, Literal: arg.Literal, Normalized: strings.ToLower(arg.Normalized)}, nil
+		})
+		e.RegisterExtensionMethod(polai.DBLQUOTESTR, "base64", func(receiver polai.SequenceItem, args []polai.SequenceItem) (polai.SequenceItem, error) {
+			encoded := base64.StdEncoding.EncodeToString([]byte(receiver.Normalized))
+			return polai.SequenceItem{Token: polai.DBLQUOTESTR, Literal: strconv.Quote(encoded), Normalized: encoded}, nil
+		})
+		e.RegisterExtensionMethod(polai.DBLQUOTESTR, "between", func(receiver polai.SequenceItem, args []polai.SequenceItem) (polai.SequenceItem, error) {
+			if len(args) != 2 {
+				return polai.SequenceItem{}, fmt.Errorf("between function expects 2 argument(s), got %d", len(args))
+			}
+			if receiver.Normalized > args[0].Normalized && receiver.Normalized < args[1].Normalized {
+				return polai.SequenceItem{Token: polai.TRUE, Literal: "true", Normalized: "true"}, nil
+			}
+			return polai.SequenceItem{Token: polai.FALSE, Literal: "false", Normalized: "false"}, nil
+		})
+
+		result, err := e.Evaluate(`User::"alice"`, `Action::"view"`, `Photo::"a.jpg"`, `{"id": "7c9e6679-7425-40de-944b-e07fc1f90ae7"}`)
+		if errstring(err) != tt.err {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%v", i, tt.name, tt.err, err)
+		} else if tt.err == "" && result != tt.exp {
+			t.Errorf("%d. %s: result mismatch: exp=%v got=%v", i, tt.name, tt.exp, result)
+		}
+	}
+}
+
+// Ensure extensions registered on an evaluator returned by BindSlots are not shared with its parent.
+func TestEvaluator_RegisterExtensionBindSlots(t *testing.T) {
+	e := polai.NewEvaluatorFromString(`permit (principal == ?principal, action, resource) when { "abc".reverse() == "cba" };`)
+	bound, err := e.BindSlots(map[string]string{"?principal": `User::"alice"`})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	bound.RegisterExtensionMethod(polai.DBLQUOTESTR, "reverse", func(receiver polai.SequenceItem, args []polai.SequenceItem) (polai.SequenceItem, error) {
+		runes := []rune(receiver.Normalized)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return polai.SequenceItem{Token: polai.DBLQUOTESTR, Literal: strconv.Quote(string(runes)), Normalized: string(runes)}, nil
+	})
+
+	if result, err := bound.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`); err != nil || !result {
+		t.Errorf("result mismatch: exp=true got=%v (%v)", result, err)
+	}
+
+	rebound, err := e.BindSlots(map[string]string{"?principal": `User::"alice"`})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := rebound.Evaluate(`User::"alice"`, `Action::"MyAction"`, `Resource::"MyResource"`, `{}`); errstring(err) != "unknown string function: reverse" {
+		t.Errorf("error mismatch: exp=unknown string function: reverse got=%v", err)
 	}
 }
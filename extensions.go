@@ -0,0 +1,838 @@
+package polai
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExtensionConstructor constructs an extension value, such as ip("10.0.0.1"), from its argument.
+type ExtensionConstructor func(arg SequenceItem) (SequenceItem, error)
+
+// ExtensionMethod applies a method, such as isInRange, to its receiver and arguments.
+type ExtensionMethod func(receiver SequenceItem, args []SequenceItem) (SequenceItem, error)
+
+// extensionConstructor is an ExtensionConstructor which is passed the evaluator calling it, so that it may depend on
+// the options of that evaluator.
+type extensionConstructor func(e *Evaluator, arg SequenceItem) (SequenceItem, error)
+
+type extensionMethodKey struct {
+	typeTok Token
+	name    string
+}
+
+// RegisterExtensionConstructor registers a function which may be called as name(arg) within a condition, replacing
+// any existing function of the same name.
+func (e *Evaluator) RegisterExtensionConstructor(name string, fn ExtensionConstructor) {
+	e.constructors[name] = func(_ *Evaluator, arg SequenceItem) (SequenceItem, error) {
+		return fn(arg)
+	}
+}
+
+// RegisterExtensionMethod registers a method which may be called as receiver.name(args...) within a condition, where
+// the receiver has the type typeTok, replacing any existing method of the same name for that type.
+func (e *Evaluator) RegisterExtensionMethod(typeTok Token, name string, fn ExtensionMethod) {
+	e.methods[extensionMethodKey{typeTok: typeTok, name: name}] = fn
+}
+
+// registerFallbackMethod registers a method which is called as receiver.name(args...) when no method of that name is
+// registered for the type of the receiver, so that it may report a more specific error.
+func (e *Evaluator) registerFallbackMethod(name string, fn ExtensionMethod) {
+	e.fallbackMethods[name] = fn
+}
+
+// registerBuiltinExtensions registers the ip, decimal, datetime and duration extensions, along with the set,
+// record and string methods.
+func (e *Evaluator) registerBuiltinExtensions() {
+	e.constructors = map[string]extensionConstructor{}
+	e.methods = map[extensionMethodKey]ExtensionMethod{}
+	e.fallbackMethods = map[string]ExtensionMethod{}
+
+	e.RegisterExtensionConstructor("ip", ipConstructor)
+	e.constructors["decimal"] = (*Evaluator).decimalConstructor
+	e.RegisterExtensionConstructor("datetime", datetimeConstructor)
+	e.RegisterExtensionConstructor("duration", durationConstructor)
+
+	e.RegisterExtensionMethod(SET, "contains", setContains)
+	e.RegisterExtensionMethod(SET, "containsAll", setContainsAll)
+	e.RegisterExtensionMethod(SET, "containsAny", setContainsAny)
+	e.RegisterExtensionMethod(SET, "union", setOperation("union"))
+	e.RegisterExtensionMethod(SET, "intersection", setOperation("intersection"))
+	e.RegisterExtensionMethod(SET, "difference", setOperation("difference"))
+	e.RegisterExtensionMethod(SET, "size", setSize)
+	e.RegisterExtensionMethod(SET, "isEmpty", setIsEmpty)
+
+	e.RegisterExtensionMethod(RECORD, "keys", recordKeys)
+	e.RegisterExtensionMethod(ATTRIBUTE, "keys", recordKeys)
+
+	e.RegisterExtensionMethod(DBLQUOTESTR, "length", stringLength)
+	e.RegisterExtensionMethod(DBLQUOTESTR, "contains", stringContains)
+	e.RegisterExtensionMethod(DBLQUOTESTR, "startsWith", stringAffix("startsWith", strings.HasPrefix))
+	e.RegisterExtensionMethod(DBLQUOTESTR, "endsWith", stringAffix("endsWith", strings.HasSuffix))
+	e.registerFallbackMethod("startsWith", stringAffixMismatch("startsWith"))
+	e.registerFallbackMethod("endsWith", stringAffixMismatch("endsWith"))
+	e.RegisterExtensionMethod(DBLQUOTESTR, "toLowerCase", stringConvert("toLowerCase", strings.ToLower))
+	e.RegisterExtensionMethod(DBLQUOTESTR, "toUpperCase", stringConvert("toUpperCase", strings.ToUpper))
+
+	e.RegisterExtensionMethod(IP, "isIpv4", ipIsIpv4)
+	e.RegisterExtensionMethod(IP, "isIpv6", ipIsIpv6)
+	e.RegisterExtensionMethod(IP, "isInRange", ipIsInRange)
+	e.RegisterExtensionMethod(IP, "isLoopback", ipIsLoopback)
+	e.RegisterExtensionMethod(IP, "isMulticast", ipIsMulticast)
+	e.RegisterExtensionMethod(IP, "isUnspecified", ipIsUnspecified)
+	e.RegisterExtensionMethod(IP, "isLinkLocal", ipIsLinkLocal)
+	e.RegisterExtensionMethod(IP, "isGlobalUnicast", ipIsGlobalUnicast)
+	e.RegisterExtensionMethod(IP, "isPrivate", ipIsPrivate)
+	e.RegisterExtensionMethod(IP, "toIPv6", ipToIPv6)
+	e.RegisterExtensionMethod(IP, "prefixLength", ipPrefixLength)
+	e.RegisterExtensionMethod(IP, "network", ipNetwork)
+
+	e.RegisterExtensionMethod(DECIMAL, "toLong", decimalToLong)
+	e.RegisterExtensionMethod(DATETIME, "addDuration", datetimeAddDuration("addDuration", addInt64))
+	e.RegisterExtensionMethod(DATETIME, "subDuration", datetimeAddDuration("subDuration", subInt64))
+	e.RegisterExtensionMethod(DURATION, "toMilliseconds", durationToMilliseconds)
+	for _, name := range []string{"lessThan", "lessThanOrEqual", "greaterThan", "greaterThanOrEqual"} {
+		e.RegisterExtensionMethod(DECIMAL, name, decimalCompare(name))
+		e.RegisterExtensionMethod(DATETIME, name, datetimeCompare(name))
+	}
+}
+
+// callExtensionMethod calls the method registered for the type of the receiver, or else the fallback method
+// registered for its name.
+func (e *Evaluator) callExtensionMethod(receiver SequenceItem, name string, args []SequenceItem) (SequenceItem, error) {
+	if fn, ok := e.methods[extensionMethodKey{typeTok: receiver.Token, name: name}]; ok {
+		return fn(receiver, args)
+	}
+	if fn, ok := e.fallbackMethods[name]; ok {
+		return fn(receiver, args)
+	}
+
+	// a method taking arguments is identified by its name, whereas one without is looked up on the type of its
+	// receiver, so that e.g. "a".network() is reported as unknown for strings
+	if len(args) > 0 && e.isMethod(name) {
+		return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("unexpected use of %s function", name)}
+	}
+
+	switch receiver.Token {
+	case DBLQUOTESTR:
+		return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("unknown string function: %s", name)}
+	case IP:
+		return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("unknown IP function: %s", name)}
+	case DECIMAL:
+		return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("unknown decimal function: %s", name)}
+	case DATETIME:
+		return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("unknown datetime function: %s", name)}
+	case DURATION:
+		return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("unknown duration function: %s", name)}
+	}
+
+	if e.isMethod(name) {
+		return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("unexpected use of %s function", name)}
+	}
+
+	return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("unknown function: %s", name)}
+}
+
+// isMethod returns whether a method of the given name is registered for any type.
+func (e *Evaluator) isMethod(name string) bool {
+	for key := range e.methods {
+		if key.name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkArgs returns an error unless the function was called with n arguments.
+func checkArgs(name string, args []SequenceItem, n int) error {
+	if len(args) != n {
+		return &EvalError{Msg: fmt.Sprintf("%s function expects %d argument(s), got %d", name, n, len(args))}
+	}
+
+	return nil
+}
+
+// compare returns the result of the named comparison between a and b.
+func compare[T int64 | float64](name string, a, b T) bool {
+	switch name {
+	case "lessThan":
+		return a < b
+	case "lessThanOrEqual":
+		return a <= b
+	case "greaterThan":
+		return a > b
+	}
+
+	return a >= b
+}
+
+func ipConstructor(arg SequenceItem) (SequenceItem, error) {
+	normalized := arg.Normalized
+	if !strings.Contains(normalized, "/") {
+		if strings.Count(normalized, ":") >= 2 {
+			normalized += "/128"
+		} else {
+			normalized += "/32"
+		}
+	}
+	_, ipNet, err := net.ParseCIDR(normalized)
+	if err != nil {
+		return SequenceItem{}, &EvalError{Msg: "invalid ip"}
+	}
+
+	return SequenceItem{
+		Token:      IP,
+		Literal:    arg.Normalized,
+		Normalized: ipNet.String(),
+	}, nil
+}
+
+// decimalConstructor constructs a decimal, rejecting strings with more fractional digits than the DecimalPrecision of
+// the evaluator.
+func (e *Evaluator) decimalConstructor(arg SequenceItem) (SequenceItem, error) {
+	lit := arg.Normalized
+
+	if arg.Token == LONG {
+		// decimals hold four fractional digits within an int64, limiting the range of whole numbers
+		val, err := strconv.ParseInt(lit, 10, 64)
+		if err != nil || val > math.MaxInt64/10000 || val < math.MinInt64/10000 {
+			return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("long %s out of range for decimal", lit)}
+		}
+		return SequenceItem{
+			Token:      DECIMAL,
+			Literal:    lit,
+			Normalized: strconv.FormatInt(val, 10) + ".0000",
+		}, nil
+	}
+
+	i := strings.IndexByte(lit, '.')
+	if i > -1 && e.DecimalPrecision > 0 {
+		if (len(lit) - i - 1) > e.DecimalPrecision {
+			return SequenceItem{}, &EvalError{Msg: "too much precision in decimal"}
+		}
+	}
+	f, err := strconv.ParseFloat(lit, 64)
+	if err != nil {
+		return SequenceItem{}, &EvalError{Msg: "error parsing decimal"}
+	}
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("decimal %s is not a finite number", lit)}
+	}
+
+	return SequenceItem{
+		Token:      DECIMAL,
+		Literal:    lit,
+		Normalized: formatDecimal(f),
+	}, nil
+}
+
+// formatDecimal formats a decimal with at least four fractional digits, so that equal decimals are formatted equally
+// regardless of their precision.
+func formatDecimal(f float64) string {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	i := strings.IndexByte(s, '.')
+	if i < 0 {
+		return s + ".0000"
+	}
+	if digits := len(s) - i - 1; digits < 4 {
+		s += strings.Repeat("0", 4-digits)
+	}
+
+	return s
+}
+
+func datetimeConstructor(arg SequenceItem) (SequenceItem, error) {
+	t, err := time.Parse(time.RFC3339Nano, arg.Normalized)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", arg.Normalized)
+	}
+	if err != nil || t.Year() < 1678 || t.Year() > 2261 {
+		return SequenceItem{}, &EvalError{Msg: "error parsing datetime"}
+	}
+
+	return datetimeSequenceItem(t), nil
+}
+
+func durationConstructor(arg SequenceItem) (SequenceItem, error) {
+	d, err := time.ParseDuration(arg.Normalized)
+	if err != nil {
+		return SequenceItem{}, &EvalError{Msg: "error parsing duration"}
+	}
+
+	return SequenceItem{
+		Token:      DURATION,
+		Literal:    arg.Normalized,
+		Normalized: strconv.FormatInt(int64(d), 10),
+	}, nil
+}
+
+// datetimeSequenceItem returns a DATETIME SequenceItem for t, normalized to nanoseconds since the Unix epoch.
+func datetimeSequenceItem(t time.Time) SequenceItem {
+	return SequenceItem{
+		Token:      DATETIME,
+		Literal:    t.Format(time.RFC3339Nano),
+		Normalized: strconv.FormatInt(t.UnixNano(), 10),
+	}
+}
+
+// unmarshalSets returns the members of the receiver and argument sets.
+func unmarshalSets(name string, receiver SequenceItem, args []SequenceItem) ([]interface{}, []interface{}, error) {
+	if err := checkArgs(name, args, 1); err != nil {
+		return nil, nil, err
+	}
+	if args[0].Token != SET {
+		return nil, nil, &EvalError{Msg: fmt.Sprintf("unexpected use of %s function", name)}
+	}
+
+	var receiverSet []interface{}
+	if err := json.Unmarshal([]byte(receiver.Normalized), &receiverSet); err != nil {
+		return nil, nil, err
+	}
+	var argSet []interface{}
+	if err := json.Unmarshal([]byte(args[0].Normalized), &argSet); err != nil {
+		return nil, nil, err
+	}
+
+	return receiverSet, argSet, nil
+}
+
+func setContains(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("contains", args, 1); err != nil {
+		return SequenceItem{}, err
+	}
+
+	var receiverSet []interface{}
+	if err := json.Unmarshal([]byte(receiver.Normalized), &receiverSet); err != nil {
+		return SequenceItem{}, err
+	}
+	for _, setItem := range receiverSet {
+		if args[0].Normalized == setItem {
+			return boolSequenceItem(true), nil
+		}
+	}
+
+	return boolSequenceItem(false), nil
+}
+
+func setContainsAll(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	receiverSet, argSet, err := unmarshalSets("containsAll", receiver, args)
+	if err != nil {
+		return SequenceItem{}, err
+	}
+
+	for _, argSetItem := range argSet {
+		found := false
+		for _, receiverSetItem := range receiverSet {
+			if argSetItem == receiverSetItem {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return boolSequenceItem(false), nil
+		}
+	}
+
+	return boolSequenceItem(true), nil
+}
+
+func setContainsAny(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	receiverSet, argSet, err := unmarshalSets("containsAny", receiver, args)
+	if err != nil {
+		return SequenceItem{}, err
+	}
+
+	for _, argSetItem := range argSet {
+		for _, receiverSetItem := range receiverSet {
+			if argSetItem == receiverSetItem {
+				return boolSequenceItem(true), nil
+			}
+		}
+	}
+
+	return boolSequenceItem(false), nil
+}
+
+// setOperation returns the union, intersection or difference method for sets. Members are compared by their JSON
+// encoding and the result keeps the order in which they first appear.
+func setOperation(name string) ExtensionMethod {
+	return func(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+		receiverSet, argSet, err := unmarshalSets(name, receiver, args)
+		if err != nil {
+			return SequenceItem{}, err
+		}
+
+		argKeys := map[string]struct{}{}
+		for _, setItem := range argSet {
+			b, err := json.Marshal(setItem)
+			if err != nil {
+				return SequenceItem{}, err
+			}
+			argKeys[string(b)] = struct{}{}
+		}
+
+		candidates := receiverSet
+		if name == "union" {
+			candidates = append(candidates, argSet...)
+		}
+
+		seen := map[string]struct{}{}
+		result := []interface{}{}
+		for _, setItem := range candidates {
+			b, err := json.Marshal(setItem)
+			if err != nil {
+				return SequenceItem{}, err
+			}
+			if _, ok := seen[string(b)]; ok {
+				continue
+			}
+			_, inArg := argKeys[string(b)]
+			if (name == "intersection" && !inArg) || (name == "difference" && inArg) {
+				continue
+			}
+			seen[string(b)] = struct{}{}
+			result = append(result, setItem)
+		}
+
+		b, err := json.Marshal(result)
+		if err != nil {
+			return SequenceItem{}, err
+		}
+		return SequenceItem{
+			Token:      SET,
+			Literal:    string(b),
+			Normalized: string(b),
+		}, nil
+	}
+}
+
+func setSize(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("size", args, 0); err != nil {
+		return SequenceItem{}, err
+	}
+
+	var receiverSet []interface{}
+	if err := json.Unmarshal([]byte(receiver.Normalized), &receiverSet); err != nil {
+		return SequenceItem{}, err
+	}
+
+	size := strconv.FormatInt(int64(len(receiverSet)), 10)
+	return SequenceItem{
+		Token:      LONG,
+		Literal:    size,
+		Normalized: size,
+	}, nil
+}
+
+func setIsEmpty(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("isEmpty", args, 0); err != nil {
+		return SequenceItem{}, err
+	}
+
+	var receiverSet []interface{}
+	if err := json.Unmarshal([]byte(receiver.Normalized), &receiverSet); err != nil {
+		return SequenceItem{}, err
+	}
+
+	return boolSequenceItem(len(receiverSet) == 0), nil
+}
+
+func recordKeys(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("keys", args, 0); err != nil {
+		return SequenceItem{}, err
+	}
+
+	keys := []string{}
+	if receiver.Token == RECORD {
+		for key := range receiver.RecordKeyValuePairs {
+			keys = append(keys, key)
+		}
+	} else {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(receiver.Normalized), &record); err != nil {
+			return SequenceItem{}, &EvalError{Msg: "keys function requires a record"}
+		}
+		for key := range record {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	b, err := json.Marshal(keys)
+	if err != nil {
+		return SequenceItem{}, err
+	}
+	return SequenceItem{
+		Token:      SET,
+		Literal:    string(b),
+		Normalized: string(b),
+	}, nil
+}
+
+func stringLength(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("length", args, 0); err != nil {
+		return SequenceItem{}, err
+	}
+
+	length := strconv.FormatInt(int64(len([]rune(receiver.Normalized))), 10)
+	return SequenceItem{
+		Token:      LONG,
+		Literal:    length,
+		Normalized: length,
+	}, nil
+}
+
+func stringContains(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("contains", args, 1); err != nil {
+		return SequenceItem{}, err
+	}
+	if args[0].Token != DBLQUOTESTR {
+		return SequenceItem{}, &EvalError{Msg: "string contains function requires a string argument"}
+	}
+
+	return boolSequenceItem(strings.Contains(receiver.Normalized, args[0].Normalized)), nil
+}
+
+// stringAffix returns a method which tests the receiver against a string argument using fn.
+func stringAffix(name string, fn func(s, affix string) bool) ExtensionMethod {
+	return func(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+		if err := checkArgs(name, args, 1); err != nil {
+			return SequenceItem{}, err
+		}
+		if args[0].Token != DBLQUOTESTR {
+			return stringAffixMismatch(name)(receiver, args)
+		}
+
+		return boolSequenceItem(fn(receiver.Normalized, args[0].Normalized)), nil
+	}
+}
+
+// stringAffixMismatch returns a method which reports that stringAffix was called on a receiver or argument which is
+// not a string.
+func stringAffixMismatch(name string) ExtensionMethod {
+	return func(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+		return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("%s function requires a string receiver and argument", name)}
+	}
+}
+
+// stringConvert returns a method which converts the receiver using fn.
+func stringConvert(name string, fn func(s string) string) ExtensionMethod {
+	return func(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+		if err := checkArgs(name, args, 0); err != nil {
+			return SequenceItem{}, err
+		}
+
+		str := fn(receiver.Normalized)
+		return SequenceItem{
+			Token:      DBLQUOTESTR,
+			Literal:    quoteString(str),
+			Normalized: str,
+		}, nil
+	}
+}
+
+func ipIsIpv4(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("isIpv4", args, 0); err != nil {
+		return SequenceItem{}, err
+	}
+
+	return boolSequenceItem(strings.Count(receiver.Normalized, ":") < 2), nil
+}
+
+func ipIsIpv6(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("isIpv6", args, 0); err != nil {
+		return SequenceItem{}, err
+	}
+
+	return boolSequenceItem(strings.Count(receiver.Normalized, ":") >= 2), nil
+}
+
+// ipRange returns the first and last addresses of the CIDR range of an IP SequenceItem.
+func ipRange(item SequenceItem) (net.IP, net.IP, error) {
+	_, ipNet, err := net.ParseCIDR(item.Normalized)
+	if err != nil {
+		return nil, nil, &EvalError{Msg: "invalid IP"}
+	}
+
+	first := make(net.IP, len(ipNet.IP))
+	last := make(net.IP, len(ipNet.IP))
+	for i := range ipNet.IP {
+		first[i] = ipNet.IP[i] & ipNet.Mask[i]
+		last[i] = ipNet.IP[i] | ^ipNet.Mask[i]
+	}
+
+	return first, last, nil
+}
+
+func ipIsInRange(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("isInRange", args, 1); err != nil {
+		return SequenceItem{}, err
+	}
+	if args[0].Token != IP {
+		return SequenceItem{}, &EvalError{Msg: "unexpected use of isInRange function"}
+	}
+
+	_, ipNet, err := net.ParseCIDR(args[0].Normalized)
+	if err != nil {
+		return SequenceItem{}, &EvalError{Msg: "invalid IP"}
+	}
+	first, last, err := ipRange(receiver)
+	if err != nil {
+		return SequenceItem{}, err
+	}
+
+	return boolSequenceItem(ipNet.Contains(first) && ipNet.Contains(last)), nil
+}
+
+func ipIsLoopback(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("isLoopback", args, 0); err != nil {
+		return SequenceItem{}, err
+	}
+
+	first, last, err := ipRange(receiver)
+	if err != nil {
+		return SequenceItem{}, err
+	}
+
+	return boolSequenceItem(first.IsLoopback() && last.IsLoopback()), nil
+}
+
+func ipIsMulticast(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("isMulticast", args, 0); err != nil {
+		return SequenceItem{}, err
+	}
+
+	first, last, err := ipRange(receiver)
+	if err != nil {
+		return SequenceItem{}, err
+	}
+
+	return boolSequenceItem(first.IsMulticast() && last.IsMulticast()), nil
+}
+
+func ipIsUnspecified(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("isUnspecified", args, 0); err != nil {
+		return SequenceItem{}, err
+	}
+
+	first, last, err := ipRange(receiver)
+	if err != nil {
+		return SequenceItem{}, err
+	}
+
+	return boolSequenceItem(first.IsUnspecified() && last.IsUnspecified()), nil
+}
+
+func ipIsLinkLocal(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("isLinkLocal", args, 0); err != nil {
+		return SequenceItem{}, err
+	}
+
+	first, last, err := ipRange(receiver)
+	if err != nil {
+		return SequenceItem{}, err
+	}
+
+	return boolSequenceItem(first.IsLinkLocalUnicast() && last.IsLinkLocalUnicast()), nil
+}
+
+func ipIsGlobalUnicast(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("isGlobalUnicast", args, 0); err != nil {
+		return SequenceItem{}, err
+	}
+
+	first, last, err := ipRange(receiver)
+	if err != nil {
+		return SequenceItem{}, err
+	}
+
+	return boolSequenceItem(first.IsGlobalUnicast() && last.IsGlobalUnicast()), nil
+}
+
+func ipIsPrivate(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("isPrivate", args, 0); err != nil {
+		return SequenceItem{}, err
+	}
+
+	first, last, err := ipRange(receiver)
+	if err != nil {
+		return SequenceItem{}, err
+	}
+
+	return boolSequenceItem(first.IsPrivate() && last.IsPrivate()), nil
+}
+
+func ipToIPv6(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("toIPv6", args, 0); err != nil {
+		return SequenceItem{}, err
+	}
+
+	_, ipNet, err := net.ParseCIDR(receiver.Normalized)
+	if err != nil {
+		return SequenceItem{}, &EvalError{Msg: "invalid IP"}
+	}
+
+	if strings.Count(receiver.Normalized, ":") >= 2 {
+		return receiver, nil
+	}
+	ones, _ := ipNet.Mask.Size()
+	normalized := fmt.Sprintf("::ffff:%s/%d", ipNet.IP.To4().String(), ones+96)
+	return SequenceItem{
+		Token:      IP,
+		Literal:    normalized,
+		Normalized: normalized,
+	}, nil
+}
+
+func ipPrefixLength(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("prefixLength", args, 0); err != nil {
+		return SequenceItem{}, err
+	}
+
+	_, ipNet, err := net.ParseCIDR(receiver.Normalized)
+	if err != nil {
+		return SequenceItem{}, &EvalError{Msg: "invalid IP"}
+	}
+
+	prefixLength := 0
+	for _, b := range ipNet.Mask {
+		prefixLength += bits.OnesCount8(b)
+	}
+	return SequenceItem{
+		Token:      LONG,
+		Literal:    strconv.Itoa(prefixLength),
+		Normalized: strconv.Itoa(prefixLength),
+	}, nil
+}
+
+func ipNetwork(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("network", args, 0); err != nil {
+		return SequenceItem{}, err
+	}
+
+	_, ipNet, err := net.ParseCIDR(receiver.Normalized)
+	if err != nil {
+		return SequenceItem{}, &EvalError{Msg: "invalid IP"}
+	}
+
+	return SequenceItem{
+		Token:      IP,
+		Literal:    ipNet.String(),
+		Normalized: ipNet.String(),
+	}, nil
+}
+
+func decimalToLong(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("toLong", args, 0); err != nil {
+		return SequenceItem{}, err
+	}
+
+	f, err := strconv.ParseFloat(receiver.Normalized, 64)
+	if err != nil {
+		return SequenceItem{}, &EvalError{Msg: "error parsing decimal"}
+	}
+	f = math.Trunc(f)
+	if f >= math.MaxInt64 || f < math.MinInt64 {
+		return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("integer overflow: decimal %s out of range for long", receiver.Literal)}
+	}
+
+	return SequenceItem{
+		Token:      LONG,
+		Literal:    strconv.FormatInt(int64(f), 10),
+		Normalized: strconv.FormatInt(int64(f), 10),
+	}, nil
+}
+
+// decimalCompare returns a method which performs the named comparison between two decimals.
+func decimalCompare(name string) ExtensionMethod {
+	return func(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+		if err := checkArgs(name, args, 1); err != nil {
+			return SequenceItem{}, err
+		}
+		if args[0].Token != DECIMAL {
+			return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("unexpected use of %s function", name)}
+		}
+
+		lhsD, err := strconv.ParseFloat(receiver.Normalized, 64)
+		if err != nil {
+			return SequenceItem{}, &EvalError{Msg: "error parsing decimal"}
+		}
+		rhsD, err := strconv.ParseFloat(args[0].Normalized, 64)
+		if err != nil {
+			return SequenceItem{}, &EvalError{Msg: "error parsing decimal"}
+		}
+
+		return boolSequenceItem(compare(name, lhsD, rhsD)), nil
+	}
+}
+
+// datetimeCompare returns a method which performs the named comparison between two datetimes.
+func datetimeCompare(name string) ExtensionMethod {
+	return func(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+		if err := checkArgs(name, args, 1); err != nil {
+			return SequenceItem{}, err
+		}
+		if args[0].Token != DATETIME {
+			return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("unexpected use of %s function", name)}
+		}
+
+		lhsT, lhsErr := strconv.ParseInt(receiver.Normalized, 10, 64)
+		rhsT, rhsErr := strconv.ParseInt(args[0].Normalized, 10, 64)
+		if lhsErr != nil || rhsErr != nil {
+			return SequenceItem{}, &EvalError{Msg: "error parsing datetime"}
+		}
+
+		return boolSequenceItem(compare(name, lhsT, rhsT)), nil
+	}
+}
+
+// datetimeAddDuration returns a method which offsets a datetime by a duration argument using fn.
+func datetimeAddDuration(name string, fn func(a, b int64) (int64, bool)) ExtensionMethod {
+	return func(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+		if err := checkArgs(name, args, 1); err != nil {
+			return SequenceItem{}, err
+		}
+		if args[0].Token != DURATION {
+			return SequenceItem{}, &EvalError{Msg: fmt.Sprintf("unexpected use of %s function", name)}
+		}
+
+		t, err := strconv.ParseInt(receiver.Normalized, 10, 64)
+		if err != nil {
+			return SequenceItem{}, &EvalError{Msg: "error parsing datetime"}
+		}
+		d, err := strconv.ParseInt(args[0].Normalized, 10, 64)
+		if err != nil {
+			return SequenceItem{}, &EvalError{Msg: "error parsing duration"}
+		}
+
+		result, ok := fn(t, d)
+		if !ok {
+			return SequenceItem{}, &EvalError{Msg: "datetime out of range"}
+		}
+		return datetimeSequenceItem(time.Unix(0, result).UTC()), nil
+	}
+}
+
+func durationToMilliseconds(receiver SequenceItem, args []SequenceItem) (SequenceItem, error) {
+	if err := checkArgs("toMilliseconds", args, 0); err != nil {
+		return SequenceItem{}, err
+	}
+
+	d, err := strconv.ParseInt(receiver.Normalized, 10, 64)
+	if err != nil {
+		return SequenceItem{}, &EvalError{Msg: "error parsing duration"}
+	}
+
+	return SequenceItem{
+		Token:      LONG,
+		Literal:    strconv.FormatInt(time.Duration(d).Milliseconds(), 10),
+		Normalized: strconv.FormatInt(time.Duration(d).Milliseconds(), 10),
+	}, nil
+}
@@ -0,0 +1,33 @@
+package polai
+
+// LintWarningType represents the kind of a lint warning.
+type LintWarningType int
+
+const (
+	// StaticDenyAllWarning indicates a forbid statement that applies to every request.
+	StaticDenyAllWarning LintWarningType = iota
+)
+
+// LintWarning represents a potential problem found within a policy statement.
+type LintWarning struct {
+	Type           LintWarningType
+	StatementIndex int
+	Message        string
+}
+
+// Lint performs static checks on policy statements and returns any warnings found, in statement order.
+func Lint(stmts []PolicyStatement) []LintWarning {
+	var warnings []LintWarning
+
+	for i, stmt := range stmts {
+		if stmt.isStaticDenyAll() {
+			warnings = append(warnings, LintWarning{
+				Type:           StaticDenyAllWarning,
+				StatementIndex: i,
+				Message:        "forbid statement has no scope restrictions or conditions and denies all requests",
+			})
+		}
+	}
+
+	return warnings
+}
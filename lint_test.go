@@ -0,0 +1,44 @@
+package polai_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/iann0036/polai"
+)
+
+// Ensure the linter reports potential problems in policy statements.
+func TestLint(t *testing.T) {
+	var tests = []struct {
+		s        string
+		warnings []polai.LintWarning
+	}{
+		{
+			s: `
+			permit (principal, action, resource);
+			forbid (principal, action, resource) when { context.x };
+			forbid (principal, action, resource);`,
+			warnings: []polai.LintWarning{
+				{
+					Type:           polai.StaticDenyAllWarning,
+					StatementIndex: 2,
+					Message:        "forbid statement has no scope restrictions or conditions and denies all requests",
+				},
+			},
+		},
+		{
+			s: `forbid (principal, action == Action::"delete", resource);`,
+		},
+	}
+
+	for i, tt := range tests {
+		stmts, err := polai.NewParser(strings.NewReader(tt.s)).Parse()
+		if err != nil {
+			t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+		}
+		if warnings := polai.Lint(stmts); !reflect.DeepEqual(tt.warnings, warnings) {
+			t.Errorf("%d. %q\n\nwarning mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.s, tt.warnings, warnings)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package polai
+
+import "time"
+
+// Options represents optional parser and evaluator behaviour.
+type Options struct {
+	// StrictParsing rejects statements which are syntactically valid but almost certainly a mistake.
+	StrictParsing bool
+
+	// MaxRecursionDepth limits how deeply the evaluator may recurse whilst evaluating nested expressions.
+	MaxRecursionDepth int
+
+	// Clock returns the current time, as used by the now() function.
+	Clock func() time.Time
+}
+
+// Option configures an Options value.
+type Option func(*Options)
+
+// WithStrictParsing enables strict parsing, which returns an error for statements such as a forbid with no scope
+// restrictions and no conditions.
+func WithStrictParsing() Option {
+	return func(o *Options) {
+		o.StrictParsing = true
+	}
+}
+
+// WithMaxRecursionDepth sets the maximum depth the evaluator may recurse whilst evaluating nested expressions, such
+// as record values. The default is 50.
+func WithMaxRecursionDepth(n int) Option {
+	return func(o *Options) {
+		o.MaxRecursionDepth = n
+	}
+}
+
+// WithClock sets the function used to determine the current time for the now() function. The default is time.Now.
+func WithClock(clock func() time.Time) Option {
+	return func(o *Options) {
+		o.Clock = clock
+	}
+}
+
+// newOptions returns the Options produced by applying opts to the defaults.
+func newOptions(opts ...Option) Options {
+	o := Options{
+		MaxRecursionDepth: 50,
+		Clock:             time.Now,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
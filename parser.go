@@ -7,34 +7,84 @@ import (
 	"strings"
 )
 
+// Template slots which may appear within the scope of a policy template.
+const (
+	PrincipalSlot = "?principal"
+	ResourceSlot  = "?resource"
+)
+
 // PolicyStatement represents a set of Cedar policy statements
 type PolicyStatement struct {
 	Effect          Token
 	AnyPrincipal    bool
 	Principal       string
 	PrincipalParent string
+	PrincipalType   string
 	AnyAction       bool
 	Action          string
 	ActionParents   []string
+	ActionType      string
 	AnyResource     bool
 	Resource        string
 	ResourceParent  string
+	ResourceType    string
 	Conditions      []ConditionClause
+	Annotations     map[string]string
+}
+
+// IsTemplate returns true if the statement scope contains an unbound ?principal or ?resource slot.
+func (stmt *PolicyStatement) IsTemplate() bool {
+	return stmt.Principal == PrincipalSlot || stmt.PrincipalParent == PrincipalSlot || stmt.Resource == ResourceSlot || stmt.ResourceParent == ResourceSlot
+}
+
+// isStaticDenyAll returns true if the statement is a forbid which applies to every request.
+func (stmt *PolicyStatement) isStaticDenyAll() bool {
+	return stmt.Effect == FORBID && stmt.AnyPrincipal && stmt.AnyAction && stmt.AnyResource && len(stmt.Conditions) == 0
+}
+
+// WhenClauses returns the when condition clauses of the statement, in declaration order.
+func (stmt *PolicyStatement) WhenClauses() []ConditionClause {
+	return stmt.conditionClauses(WHEN)
+}
+
+// UnlessClauses returns the unless condition clauses of the statement, in declaration order.
+func (stmt *PolicyStatement) UnlessClauses() []ConditionClause {
+	return stmt.conditionClauses(UNLESS)
+}
+
+func (stmt *PolicyStatement) conditionClauses(condType Token) []ConditionClause {
+	var clauses []ConditionClause
+
+	for _, cond := range stmt.Conditions {
+		if cond.Type == condType {
+			clauses = append(clauses, cond)
+		}
+	}
+
+	return clauses
 }
 
 type ConditionClause struct {
 	Type     Token
 	Sequence []SequenceItem
+	Bindings []LetBinding
 }
 
-func (cc *ConditionClause) ToString() string {
-	ret := fmt.Sprintf("%q", cc.Type)
+// LetBinding represents a let variable binding within a condition clause, e.g. let x = context.a;
+// References to the binding appear in sequences as LETIDENT items, with the binding index as the normalized value.
+type LetBinding struct {
+	Name     string
+	Sequence []SequenceItem
+}
 
+// ToString returns a representation of the condition clause for debugging, e.g. when { 1 == 1 }.
+func (cc *ConditionClause) ToString() string {
+	literals := make([]string, 0, len(cc.Sequence))
 	for _, seqItem := range cc.Sequence {
-		ret += seqItem.Literal
+		literals = append(literals, seqItem.Literal)
 	}
 
-	return ret
+	return fmt.Sprintf("%s { %s }", strings.ToLower(cc.Type.String()), strings.Join(literals, " "))
 }
 
 type SequenceItem struct {
@@ -43,205 +93,313 @@ type SequenceItem struct {
 	Normalized string
 
 	RecordKeyValuePairs map[string]SequenceItem
+
+	err   error // the typed error behind an ERROR item, if any
+	arity int   // the number of items a method call applies to, including the receiver
 }
 
 // Parser represents a parser.
 type Parser struct {
-	s   *Scanner
-	buf struct {
+	s    *Scanner
+	opts Options
+	buf  struct {
 		tok Token  // last read token
 		lit string // last read literal
+		pos Pos    // position of last read token
 		n   int    // buffer size (max=1)
 	}
 }
 
 // NewParser returns a new instance of Parser.
-func NewParser(r io.Reader) *Parser {
-	return &Parser{s: NewScanner(r)}
+func NewParser(r io.Reader, opts ...Option) *Parser {
+	return &Parser{s: NewScanner(r), opts: newOptions(opts...)}
 }
 
 // Parse parses a policy.
-func (p *Parser) Parse() (*[]PolicyStatement, error) {
-	stmts := []PolicyStatement{}
+// Parsing continues past a statement containing a syntax error so that every error can be reported. When any
+// errors are found, the successfully parsed statements are returned along with a ParseErrors.
+func (p *Parser) Parse() (PolicySet, error) {
+	stmts := PolicySet{}
+	var errs ParseErrors
 
 	tok, lit := p.scanIgnoreWhitespace()
-	if tok == EOF {
-		return &stmts, nil
+	for tok != EOF {
+		stmt, err := p.parseStatement(tok, lit)
+		if err != nil {
+			pe, ok := err.(*ParseError)
+			if !ok {
+				pe = &ParseError{Pos: p.buf.pos, Msg: err.Error()}
+			}
+			errs = append(errs, pe)
+			p.skipStatement()
+		} else {
+			stmts = append(stmts, *stmt)
+		}
+
+		tok, lit = p.scanIgnoreWhitespace()
 	}
 
-	for tok != EOF {
-		stmt := PolicyStatement{
-			AnyPrincipal: true,
-			AnyAction:    true,
-			AnyResource:  true,
+	if len(errs) > 0 {
+		return stmts, errs
+	}
+
+	return stmts, nil
+}
+
+// parseStatement parses a single policy statement, beginning with the already scanned token.
+func (p *Parser) parseStatement(tok Token, lit string) (*PolicyStatement, error) {
+	stmt := PolicyStatement{
+		AnyPrincipal: true,
+		AnyAction:    true,
+		AnyResource:  true,
+	}
+
+	// Annotations
+
+	for tok == ATSIGN {
+		pos := p.buf.pos
+		key, value, err := p.scanAnnotation()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := stmt.Annotations[key]; ok {
+			return nil, &ParseError{Pos: pos, Msg: fmt.Sprintf("duplicate annotation %q", key)}
 		}
+		if stmt.Annotations == nil {
+			stmt.Annotations = map[string]string{}
+		}
+		stmt.Annotations[key] = value
 
-		// Head
+		tok, lit = p.scanIgnoreWhitespace()
+	}
 
-		switch tok {
-		case PERMIT, FORBID:
-			stmt.Effect = tok
-		default:
-			return nil, fmt.Errorf("found %q, expected permit or forbid", lit)
+	// Head
+
+	switch tok {
+	case PERMIT, FORBID:
+		stmt.Effect = tok
+	default:
+		return nil, p.errorf("found %q, expected permit or forbid", lit)
+	}
+
+	if tok, lit = p.scanIgnoreWhitespace(); tok != LEFT_PAREN {
+		return nil, p.errorf("found %q, expected left parentheses", lit)
+	}
+
+	if tok, lit = p.scanIgnoreWhitespace(); tok != PRINCIPAL {
+		return nil, p.errorf("found %q, expected principal", lit)
+	}
+
+	tok, lit = p.scanIgnoreWhitespace()
+	switch tok {
+	case COMMA:
+	case EQUALITY:
+		stmt.AnyPrincipal = false
+
+		entityName, err := p.scanEntityOrSlot(PrincipalSlot)
+		if err != nil {
+			return nil, err
 		}
+		stmt.Principal = entityName
 
-		if tok, lit = p.scanIgnoreWhitespace(); tok != LEFT_PAREN {
-			return nil, fmt.Errorf("found %q, expected left parentheses", lit)
+		if tok, lit = p.scanIgnoreWhitespace(); tok != COMMA {
+			return nil, p.errorf("found %q, expected comma", lit)
 		}
+	case IN:
+		stmt.AnyPrincipal = false
 
-		if tok, lit = p.scanIgnoreWhitespace(); tok != PRINCIPAL {
-			return nil, fmt.Errorf("found %q, expected principal", lit)
+		entityName, err := p.scanEntityOrSlot(PrincipalSlot)
+		if err != nil {
+			return nil, err
 		}
+		stmt.PrincipalParent = entityName
 
-		tok, lit = p.scanIgnoreWhitespace()
-		switch tok {
-		case COMMA:
-		case EQUALITY:
-			stmt.AnyPrincipal = false
+		if tok, lit = p.scanIgnoreWhitespace(); tok != COMMA {
+			return nil, p.errorf("found %q, expected comma", lit)
+		}
+	case IS:
+		stmt.AnyPrincipal = false
 
-			entityName, err := p.scanEntity()
-			if err != nil {
-				return nil, err
-			}
-			stmt.Principal = entityName
+		entityType, err := p.scanEntityType()
+		if err != nil {
+			return nil, err
+		}
+		stmt.PrincipalType = entityType
 
-			if tok, lit = p.scanIgnoreWhitespace(); tok != COMMA {
-				return nil, fmt.Errorf("found %q, expected comma", lit)
-			}
-		case IN:
-			stmt.AnyPrincipal = false
+		if tok, lit = p.scanIgnoreWhitespace(); tok == IN {
+			return nil, p.errorf("principal is cannot be combined with in within the scope")
+		} else if tok != COMMA {
+			return nil, p.errorf("found %q, expected comma", lit)
+		}
+	default:
+		return nil, p.errorf("found %q, expected comma, equality operator, in, or is", lit)
+	}
 
-			entityName, err := p.scanEntity()
-			if err != nil {
-				return nil, err
-			}
-			stmt.PrincipalParent = entityName
+	if tok, lit = p.scanIgnoreWhitespace(); tok != ACTION {
+		return nil, p.errorf("found %q, expected action", lit)
+	}
 
-			if tok, lit = p.scanIgnoreWhitespace(); tok != COMMA {
-				return nil, fmt.Errorf("found %q, expected comma", lit)
-			}
-		default:
-			return nil, fmt.Errorf("found %q, expected comma, equality operator, or in", lit)
+	tok, lit = p.scanIgnoreWhitespace()
+	switch tok {
+	case COMMA:
+	case EQUALITY:
+		stmt.AnyAction = false
+
+		entityName, err := p.scanEntity()
+		if err != nil {
+			return nil, err
 		}
+		stmt.Action = entityName
 
-		if tok, lit = p.scanIgnoreWhitespace(); tok != ACTION {
-			return nil, fmt.Errorf("found %q, expected action", lit)
+		if tok, lit = p.scanIgnoreWhitespace(); tok != COMMA {
+			return nil, p.errorf("found %q, expected comma", lit)
 		}
+	case IN:
+		stmt.AnyAction = false
 
 		tok, lit = p.scanIgnoreWhitespace()
-		switch tok {
-		case COMMA:
-		case EQUALITY:
-			stmt.AnyAction = false
 
+		if tok == IDENT {
+			p.unscan()
 			entityName, err := p.scanEntity()
 			if err != nil {
 				return nil, err
 			}
-			stmt.Action = entityName
-
-			if tok, lit = p.scanIgnoreWhitespace(); tok != COMMA {
-				return nil, fmt.Errorf("found %q, expected comma", lit)
-			}
-		case IN:
-			stmt.AnyAction = false
+			stmt.ActionParents = []string{entityName}
+		} else if tok == LEFT_SQB {
+			tok = COMMA
 
-			tok, lit = p.scanIgnoreWhitespace()
+			for tok != RIGHT_SQB {
+				if tok != COMMA {
+					return nil, p.errorf("found %q, expected comma or right square bracket", lit)
+				}
 
-			if tok == IDENT {
-				p.unscan()
 				entityName, err := p.scanEntity()
 				if err != nil {
 					return nil, err
 				}
-				stmt.ActionParents = []string{entityName}
-			} else if tok == LEFT_SQB {
-				tok = COMMA
-
-				for tok != RIGHT_SQB {
-					if tok != COMMA {
-						return nil, fmt.Errorf("found %q, expected comma or right square bracket", lit)
-					}
-
-					entityName, err := p.scanEntity()
-					if err != nil {
-						return nil, err
-					}
-					stmt.ActionParents = append(stmt.ActionParents, entityName)
-
-					tok, lit = p.scanIgnoreWhitespace()
-				}
-			} else {
-				return nil, fmt.Errorf("found %q, expected entity or left square bracket", lit)
-			}
+				stmt.ActionParents = append(stmt.ActionParents, entityName)
 
-			if tok, lit = p.scanIgnoreWhitespace(); tok != COMMA {
-				return nil, fmt.Errorf("found %q, expected comma", lit)
+				tok, lit = p.scanIgnoreWhitespace()
 			}
-		default:
-			return nil, fmt.Errorf("found %q, expected comma, equality operator, or in", lit)
+		} else {
+			return nil, p.errorf("found %q, expected entity or left square bracket", lit)
 		}
 
-		if tok, lit = p.scanIgnoreWhitespace(); tok != RESOURCE {
-			return nil, fmt.Errorf("found %q, expected resource", lit)
+		if tok, lit = p.scanIgnoreWhitespace(); tok != COMMA {
+			return nil, p.errorf("found %q, expected comma", lit)
 		}
+	case IS:
+		stmt.AnyAction = false
 
-		tok, lit = p.scanIgnoreWhitespace()
-		switch tok {
-		case RIGHT_PAREN:
-		case EQUALITY:
-			stmt.AnyResource = false
+		entityType, err := p.scanEntityType()
+		if err != nil {
+			return nil, err
+		}
+		stmt.ActionType = entityType
 
-			entityName, err := p.scanEntity()
-			if err != nil {
-				return nil, err
-			}
-			stmt.Resource = entityName
+		if tok, lit = p.scanIgnoreWhitespace(); tok == IN {
+			return nil, p.errorf("action is cannot be combined with in within the scope")
+		} else if tok != COMMA {
+			return nil, p.errorf("found %q, expected comma", lit)
+		}
+	default:
+		return nil, p.errorf("found %q, expected comma, equality operator, in, or is", lit)
+	}
 
-			if tok, lit = p.scanIgnoreWhitespace(); tok != RIGHT_PAREN {
-				return nil, fmt.Errorf("found %q, expected right parentheses", lit)
-			}
-		case IN:
-			stmt.AnyResource = false
+	if tok, lit = p.scanIgnoreWhitespace(); tok != RESOURCE {
+		return nil, p.errorf("found %q, expected resource", lit)
+	}
 
-			entityName, err := p.scanEntity()
-			if err != nil {
-				return nil, err
-			}
-			stmt.ResourceParent = entityName
+	tok, lit = p.scanIgnoreWhitespace()
+	switch tok {
+	case RIGHT_PAREN:
+	case EQUALITY:
+		stmt.AnyResource = false
 
-			if tok, lit = p.scanIgnoreWhitespace(); tok != RIGHT_PAREN {
-				return nil, fmt.Errorf("found %q, expected right parentheses", lit)
-			}
-		default:
-			return nil, fmt.Errorf("found %q, expected right parentheses, equality operator, or in", lit)
+		entityName, err := p.scanEntityOrSlot(ResourceSlot)
+		if err != nil {
+			return nil, err
 		}
+		stmt.Resource = entityName
 
-		// Condition Clauses
+		if tok, lit = p.scanIgnoreWhitespace(); tok != RIGHT_PAREN {
+			return nil, p.errorf("found %q, expected right parentheses", lit)
+		}
+	case IN:
+		stmt.AnyResource = false
 
-		tok, lit = p.scanIgnoreWhitespace()
+		entityName, err := p.scanEntityOrSlot(ResourceSlot)
+		if err != nil {
+			return nil, err
+		}
+		stmt.ResourceParent = entityName
 
-		for tok == WHEN || tok == UNLESS {
-			condClause, err := p.scanConditionClause(tok)
-			if err != nil {
-				return nil, err
-			}
+		if tok, lit = p.scanIgnoreWhitespace(); tok != RIGHT_PAREN {
+			return nil, p.errorf("found %q, expected right parentheses", lit)
+		}
+	case IS:
+		stmt.AnyResource = false
 
-			stmt.Conditions = append(stmt.Conditions, *condClause)
+		entityType, err := p.scanEntityType()
+		if err != nil {
+			return nil, err
+		}
+		stmt.ResourceType = entityType
 
-			tok, lit = p.scanIgnoreWhitespace()
+		if tok, lit = p.scanIgnoreWhitespace(); tok == IN {
+			return nil, p.errorf("resource is cannot be combined with in within the scope")
+		} else if tok != RIGHT_PAREN {
+			return nil, p.errorf("found %q, expected right parentheses", lit)
 		}
+	default:
+		return nil, p.errorf("found %q, expected right parentheses, equality operator, in, or is", lit)
+	}
+
+	// Condition Clauses
 
-		if tok != SEMICOLON {
-			return nil, fmt.Errorf("found %q, expected semicolon", lit)
+	tok, lit = p.scanIgnoreWhitespace()
+
+	for tok == WHEN || tok == UNLESS {
+		condClause, err := p.scanConditionClause(tok)
+		if err != nil {
+			return nil, err
 		}
 
-		stmts = append(stmts, stmt)
+		stmt.Conditions = append(stmt.Conditions, *condClause)
 
 		tok, lit = p.scanIgnoreWhitespace()
 	}
 
-	return &stmts, nil
+	if tok != SEMICOLON {
+		return nil, p.errorf("found %q, expected semicolon", lit)
+	}
+
+	if p.opts.StrictParsing && stmt.isStaticDenyAll() {
+		return nil, p.errorf("forbid statement has no scope restrictions or conditions and denies all requests")
+	}
+
+	return &stmt, nil
+}
+
+// skipStatement discards tokens up to and including the semicolon ending the statement, so that parsing can
+// resume at the following statement.
+func (p *Parser) skipStatement() {
+	tok := p.buf.tok
+	if p.buf.n != 0 {
+		tok, _ = p.scan()
+	}
+	for tok != EOF {
+		if tok == SEMICOLON {
+			// let bindings are also terminated by semicolons, so only stop where a new statement follows
+			next, _ := p.scanIgnoreWhitespace()
+			p.unscan()
+			if next == EOF || next == PERMIT || next == FORBID || next == ATSIGN {
+				return
+			}
+		}
+		tok, _ = p.scan()
+	}
 }
 
 // scan returns the next token from the underlying scanner.
@@ -258,6 +416,7 @@ func (p *Parser) scan() (tok Token, lit string) {
 
 	// Save it to the buffer in case we unscan later.
 	p.buf.tok, p.buf.lit = tok, lit
+	p.buf.pos.Line, p.buf.pos.Col = p.s.Pos()
 
 	return
 }
@@ -271,71 +430,161 @@ func (p *Parser) scanIgnoreWhitespace() (tok Token, lit string) {
 	return
 }
 
-// scanConditionClause scans a condition clause
+// scanConditionClause scans a when or unless block, including any leading let bindings.
 func (p *Parser) scanConditionClause(condType Token) (condClause *ConditionClause, err error) {
 	condClause = &ConditionClause{
 		Type: condType,
 	}
 
 	if tok, lit := p.scanIgnoreWhitespace(); tok != LEFT_BRACE {
-		return nil, fmt.Errorf("found %q, expected left brace", lit)
+		return nil, p.errorf("found %q, expected left brace", lit)
+	}
+
+	for tok, _ := p.scanIgnoreWhitespace(); tok == LETBIND; tok, _ = p.scanIgnoreWhitespace() {
+		binding, err := p.scanLetBinding(condClause.Bindings)
+		if err != nil {
+			return nil, err
+		}
+		condClause.Bindings = append(condClause.Bindings, *binding)
+	}
+	p.unscan()
+
+	condClause.Sequence, err = p.scanSequence(RIGHT_BRACE, condClause.Bindings, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return condClause, nil
+}
+
+// scanLetBinding scans the remainder of a let binding, e.g. x = context.a; following the let keyword.
+func (p *Parser) scanLetBinding(bindings []LetBinding) (*LetBinding, error) {
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok != IDENT {
+		return nil, p.errorf("found %q, expected let binding name", lit)
 	}
+	name := lit
+
+	if tok, lit = p.scanIgnoreWhitespace(); tok != ASSIGN {
+		return nil, p.errorf("found %q, expected assignment", lit)
+	}
+
+	seq, err := p.scanSequence(SEMICOLON, bindings, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(seq) == 0 {
+		return nil, p.errorf("let binding %q has no value", name)
+	}
+
+	return &LetBinding{
+		Name:     name,
+		Sequence: seq,
+	}, nil
+}
 
+// scanSequence scans condition tokens until the end token is found outside of any record braces. Identifiers
+// which refer to an earlier let binding are returned as LETIDENT items. The binding parameter holds the name of
+// the let binding being scanned, if any, which may not refer to itself.
+func (p *Parser) scanSequence(end Token, bindings []LetBinding, binding string) (seq []SequenceItem, err error) {
 	braceLevel := 0
 
 	tok, lit := p.scanIgnoreWhitespace()
-	for tok != RIGHT_BRACE || braceLevel > 0 {
+	for tok != end || braceLevel > 0 {
 		switch tok {
 		case LEFT_BRACE:
-			condClause.Sequence = append(condClause.Sequence, SequenceItem{
+			seq = append(seq, SequenceItem{
 				Token:      tok,
 				Literal:    lit,
 				Normalized: lit,
 			})
 			braceLevel++
 		case RIGHT_BRACE:
-			condClause.Sequence = append(condClause.Sequence, SequenceItem{
+			if braceLevel == 0 {
+				return nil, p.errorf("found %q, expected semicolon", lit)
+			}
+			seq = append(seq, SequenceItem{
 				Token:      tok,
 				Literal:    lit,
 				Normalized: lit,
 			})
 			braceLevel--
+		case IS, LETBIND:
+			// keywords remain usable as attribute names following has, and as record keys
+			if len(seq) > 0 && seq[len(seq)-1].Token == HAS {
+				seq = append(seq, SequenceItem{
+					Token:      ATTRIBUTE,
+					Literal:    lit,
+					Normalized: lit,
+				})
+				break
+			}
+			if braceLevel > 0 && (seq[len(seq)-1].Token == LEFT_BRACE || seq[len(seq)-1].Token == COMMA) {
+				next, _ := p.scan()
+				p.unscan()
+				if next == COLON {
+					seq = append(seq, SequenceItem{
+						Token:      RECORDKEY,
+						Literal:    lit,
+						Normalized: lit,
+					})
+					break
+				}
+			}
+			if tok == LETBIND {
+				return nil, p.errorf("unexpected token found in condition clause %q (%v)", lit, tok)
+			}
+
+			seq = append(seq, SequenceItem{
+				Token:      tok,
+				Literal:    lit,
+				Normalized: lit,
+			})
+			entityType, err := p.scanEntityType()
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, SequenceItem{
+				Token:      IDENT,
+				Literal:    entityType,
+				Normalized: entityType,
+			})
 		case IDENT:
-			if len(condClause.Sequence) < 1 || condClause.Sequence[len(condClause.Sequence)-1].Token != HAS {
+			if len(seq) < 1 || seq[len(seq)-1].Token != HAS {
 				p.unscan()
-				item, err := p.scanEntityOrFunctionOrRecordKey()
+				item, err := p.scanEntityOrFunctionOrRecordKey(bindings, binding)
 				if err != nil {
 					return nil, err
 				}
-				condClause.Sequence = append(condClause.Sequence, item)
+				seq = append(seq, item)
 			} else {
-				condClause.Sequence = append(condClause.Sequence, SequenceItem{
+				seq = append(seq, SequenceItem{
 					Token:      ATTRIBUTE,
 					Literal:    lit,
 					Normalized: lit,
 				})
 			}
 		case PERIOD:
-			condClause.Sequence = append(condClause.Sequence, SequenceItem{
+			seq = append(seq, SequenceItem{
 				Token:      tok,
 				Literal:    lit,
 				Normalized: lit,
 			})
 			tok, lit := p.scan()
-			if tok != IDENT {
-				return nil, fmt.Errorf("found %q, expected attribute or function", lit)
+			if tok != IDENT && tok != IS && tok != LETBIND {
+				return nil, p.errorf("found %q, expected attribute or function", lit)
 			}
 			tok, _ = p.scan()
 			if tok != LEFT_PAREN {
 				p.unscan()
-				condClause.Sequence = append(condClause.Sequence, SequenceItem{
+				seq = append(seq, SequenceItem{
 					Token:      ATTRIBUTE,
 					Literal:    lit,
 					Normalized: lit,
 				})
 			} else {
 				p.unscan()
-				condClause.Sequence = append(condClause.Sequence, SequenceItem{
+				seq = append(seq, SequenceItem{
 					Token:      FUNCTION,
 					Literal:    lit,
 					Normalized: lit,
@@ -344,33 +593,149 @@ func (p *Parser) scanConditionClause(condType Token) (condClause *ConditionClaus
 		case LONG:
 			i, err := strconv.ParseInt(lit, 10, 64)
 			if err != nil {
-				return nil, fmt.Errorf("error parsing long")
+				return nil, p.errorf("error parsing long")
 			}
-			condClause.Sequence = append(condClause.Sequence, SequenceItem{
+			// a long directly followed by an identifier and a namespace separator, such as 1A::"x", is an entity
+			// namespace starting with a digit
+			if next, rest := p.scan(); next == IDENT {
+				pos := p.buf.pos
+				if sep, _ := p.scan(); sep == NAMESPACE {
+					return nil, &ParseError{Pos: pos, Msg: fmt.Sprintf("invalid entity namespace %q, namespaces must start with a letter", lit+rest)}
+				}
+				return nil, &ParseError{Pos: pos, Msg: fmt.Sprintf("unexpected token found in condition clause %q (%v)", rest, next)}
+			}
+			p.unscan()
+			seq = append(seq, SequenceItem{
 				Token:      tok,
 				Literal:    lit,
 				Normalized: strconv.FormatInt(i, 10),
 			})
 		case DBLQUOTESTR:
-			condClause.Sequence = append(condClause.Sequence, SequenceItem{
+			str, err := unquoteString(lit)
+			if err != nil {
+				return nil, p.errorf("%s", err.Error())
+			}
+			seq = append(seq, SequenceItem{
 				Token:      tok,
 				Literal:    lit,
-				Normalized: strings.TrimSuffix(strings.TrimPrefix(lit, "\""), "\""),
+				Normalized: str,
 			})
-		case TRUE, FALSE, PRINCIPAL, ACTION, RESOURCE, CONTEXT, LEFT_SQB, LEFT_PAREN, RIGHT_SQB, RIGHT_PAREN, COMMA, HAS, LIKE, EQUALITY, INEQUALITY, LT, LTE, GT, GTE, IN, EXCLAMATION, DASH, PLUS, MULTIPLIER, AND, OR, IF, THEN, ELSE, COLON:
-			condClause.Sequence = append(condClause.Sequence, SequenceItem{
+		case LEFT_SQB:
+			if len(seq) < 1 || !isAttributeAccessible(seq[len(seq)-1].Token) {
+				seq = append(seq, SequenceItem{
+					Token:      tok,
+					Literal:    lit,
+					Normalized: lit,
+				})
+				break
+			}
+
+			// bracket notation, e.g. context["key"], is equivalent to attribute access
+			tok, lit = p.scanIgnoreWhitespace()
+			if tok != DBLQUOTESTR {
+				return nil, p.errorf("found %q, expected double quoted string", lit)
+			}
+			key, err := unquoteString(lit)
+			if err != nil {
+				return nil, p.errorf("%s", err.Error())
+			}
+			if tok, lit := p.scanIgnoreWhitespace(); tok != RIGHT_SQB {
+				return nil, p.errorf("found %q, expected right square bracket", lit)
+			}
+			seq = append(seq, SequenceItem{
+				Token:      PERIOD,
+				Literal:    ".",
+				Normalized: ".",
+			}, SequenceItem{
+				Token:      ATTRIBUTE,
+				Literal:    lit,
+				Normalized: key,
+			})
+		case EXCLAMATION:
+			negations := 1
+			for j := len(seq) - 1; j >= 0 && seq[j].Token == EXCLAMATION; j-- {
+				negations++
+			}
+			if negations > 4 {
+				return nil, p.errorf("too many consecutive negations, at most 4 are allowed")
+			}
+			seq = append(seq, SequenceItem{
+				Token:      tok,
+				Literal:    lit,
+				Normalized: lit,
+			})
+		case TRUE, FALSE, PRINCIPAL, ACTION, RESOURCE, CONTEXT, LEFT_PAREN, RIGHT_SQB, RIGHT_PAREN, COMMA, HAS, LIKE, EQUALITY, INEQUALITY, LT, LTE, GT, GTE, IN, PLUS, MULTIPLIER, SLASH, PERCENT, AND, OR, IF, THEN, ELSE, COLON:
+			seq = append(seq, SequenceItem{
+				Token:      tok,
+				Literal:    lit,
+				Normalized: lit,
+			})
+		case DASH:
+			// a dash which does not follow an operand negates the expression after it
+			if len(seq) == 0 || !isOperandEnd(seq[len(seq)-1].Token) {
+				tok = UNARYMINUS
+			}
+			seq = append(seq, SequenceItem{
 				Token:      tok,
 				Literal:    lit,
 				Normalized: lit,
 			})
 		default:
-			return nil, fmt.Errorf("unexpected token found in condition clause %q (%q, %v)", lit, tok, tok)
+			return nil, p.errorf("unexpected token found in condition clause %q (%v)", lit, tok)
 		}
 
 		tok, lit = p.scanIgnoreWhitespace()
 	}
 
-	return condClause, nil
+	return seq, nil
+}
+
+// isOperandEnd returns true if a sequence item with the token may end an operand, such that a following dash is
+// a subtraction rather than a negation.
+func isOperandEnd(tok Token) bool {
+	switch tok {
+	case TRUE, FALSE, LONG, DBLQUOTESTR, IDENT, ENTITY, ATTRIBUTE, LETIDENT, PRINCIPAL, ACTION, RESOURCE, CONTEXT, RIGHT_PAREN, RIGHT_SQB, RIGHT_BRACE:
+		return true
+	}
+
+	return false
+}
+
+// isAttributeAccessible returns true if a sequence item ending with the token may be followed by an attribute
+// access, rather than a set.
+func isAttributeAccessible(tok Token) bool {
+	switch tok {
+	case PRINCIPAL, ACTION, RESOURCE, CONTEXT, ENTITY, ATTRIBUTE, LETIDENT, RIGHT_PAREN, RIGHT_BRACE:
+		return true
+	}
+
+	return false
+}
+
+// scanAnnotation scans the remainder of an annotation, e.g. id("policy1"), following the @ sign.
+func (p *Parser) scanAnnotation() (key string, value string, err error) {
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok != IDENT {
+		return "", "", p.errorf("found %q, expected annotation name", lit)
+	}
+	key = lit
+
+	if tok, lit = p.scanIgnoreWhitespace(); tok != LEFT_PAREN {
+		return "", "", p.errorf("found %q, expected left parentheses", lit)
+	}
+
+	if tok, lit = p.scanIgnoreWhitespace(); tok != DBLQUOTESTR {
+		return "", "", p.errorf("found %q, expected double quoted string", lit)
+	}
+	if value, err = unquoteString(lit); err != nil {
+		return "", "", p.errorf("%s", err.Error())
+	}
+
+	if tok, lit = p.scanIgnoreWhitespace(); tok != RIGHT_PAREN {
+		return "", "", p.errorf("found %q, expected right parentheses", lit)
+	}
+
+	return key, value, nil
 }
 
 // scanEntity scans an entity type
@@ -378,40 +743,91 @@ func (p *Parser) scanEntity() (entityName string, err error) {
 	tok, lit := p.scanIgnoreWhitespace()
 	entityName = lit
 
+	if err := p.validateNamespaceSegment(tok, lit); err != nil {
+		return entityName, err
+	}
 	if tok != IDENT {
-		return entityName, fmt.Errorf("found %q, expected entity namespace", lit)
+		return entityName, p.errorf("found %q, expected entity namespace", lit)
 	}
+	segment := lit
 	if tok, lit = p.scan(); tok != NAMESPACE {
-		return entityName, fmt.Errorf("found %q, expected namespace separator", lit)
+		if err := p.validateNamespaceSeparator(segment, tok, lit); err != nil {
+			return entityName, err
+		}
+		return entityName, p.errorf("found %q, expected namespace separator", lit)
 	}
 	entityName += "::"
 
 	for {
 		tok, lit = p.scan()
+		if err := p.validateNamespaceSegment(tok, lit); err != nil {
+			return entityName, err
+		}
 		if tok == IDENT {
 			entityName += lit
+			segment = lit
 			if tok, lit = p.scan(); tok != NAMESPACE {
-				return entityName, fmt.Errorf("found %q, expected subnamespace separator", lit)
+				if err := p.validateNamespaceSeparator(segment, tok, lit); err != nil {
+					return entityName, err
+				}
+				return entityName, p.errorf("found %q, expected subnamespace separator", lit)
 			}
 			entityName += "::"
 		} else if tok == DBLQUOTESTR {
 			entityName += lit
 			break
 		} else {
-			return entityName, fmt.Errorf("found %q, expected double quoted string or entity namespace", lit)
+			return entityName, p.errorf("found %q, expected double quoted string or entity namespace", lit)
 		}
 	}
 
 	return entityName, nil
 }
 
+// scanEntityOrSlot scans an entity type, or the provided template slot
+func (p *Parser) scanEntityOrSlot(slot string) (entityName string, err error) {
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok == SLOT {
+		if lit != slot {
+			return lit, p.errorf("found %q, expected entity or %s", lit, slot)
+		}
+		return lit, nil
+	}
+	p.unscan()
+
+	return p.scanEntity()
+}
+
+// scanEntityType scans an entity type, such as Namespace::Type
+func (p *Parser) scanEntityType() (entityType string, err error) {
+	tok, lit := p.scanIgnoreWhitespace()
+	entityType = lit
+
+	if tok != IDENT {
+		return entityType, p.errorf("found %q, expected entity type", lit)
+	}
+
+	for {
+		if tok, _ = p.scan(); tok != NAMESPACE {
+			p.unscan()
+			break
+		}
+		if tok, lit = p.scan(); tok != IDENT {
+			return entityType, p.errorf("found %q, expected entity type", lit)
+		}
+		entityType += "::" + lit
+	}
+
+	return entityType, nil
+}
+
 // scanEntityOrFunctionOrRecordKey scans an entity, function or record key type
-func (p *Parser) scanEntityOrFunctionOrRecordKey() (item SequenceItem, err error) {
+func (p *Parser) scanEntityOrFunctionOrRecordKey(bindings []LetBinding, binding string) (item SequenceItem, err error) {
 	tok, lit := p.scanIgnoreWhitespace()
 	name := lit
 
 	if tok != IDENT {
-		return SequenceItem{}, fmt.Errorf("found %q, expected entity namespace", lit)
+		return SequenceItem{}, p.errorf("found %q, expected entity namespace", lit)
 	}
 	tok, lit = p.scan()
 	if tok == LEFT_PAREN {
@@ -429,23 +845,46 @@ func (p *Parser) scanEntityOrFunctionOrRecordKey() (item SequenceItem, err error
 			Normalized: name,
 		}, nil
 	} else if tok != NAMESPACE {
-		return SequenceItem{}, fmt.Errorf("found %q, expected namespace separator", lit)
+		for i := len(bindings) - 1; i >= 0; i-- {
+			if bindings[i].Name == name {
+				p.unscan()
+				return SequenceItem{
+					Token:      LETIDENT,
+					Literal:    name,
+					Normalized: strconv.Itoa(i),
+				}, nil
+			}
+		}
+		if name == binding {
+			return SequenceItem{}, p.errorf("let binding %q cannot refer to itself", name)
+		}
+		if err := p.validateNamespaceSeparator(name, tok, lit); err != nil {
+			return SequenceItem{}, err
+		}
+		return SequenceItem{}, p.errorf("found %q, expected namespace separator", lit)
 	}
 	name += "::"
 
 	for {
 		tok, lit = p.scan()
+		if err := p.validateNamespaceSegment(tok, lit); err != nil {
+			return SequenceItem{}, err
+		}
 		if tok == IDENT {
 			name += lit
+			segment := lit
 			if tok, lit = p.scan(); tok != NAMESPACE {
-				return SequenceItem{}, fmt.Errorf("found %q, expected subnamespace separator", lit)
+				if err := p.validateNamespaceSeparator(segment, tok, lit); err != nil {
+					return SequenceItem{}, err
+				}
+				return SequenceItem{}, p.errorf("found %q, expected subnamespace separator", lit)
 			}
 			name += "::"
 		} else if tok == DBLQUOTESTR {
 			name += lit
 			break
 		} else {
-			return SequenceItem{}, fmt.Errorf("found %q, expected double quoted string or entity namespace", lit)
+			return SequenceItem{}, p.errorf("found %q, expected double quoted string or entity namespace", lit)
 		}
 	}
 
@@ -456,5 +895,36 @@ func (p *Parser) scanEntityOrFunctionOrRecordKey() (item SequenceItem, err error
 	}, nil
 }
 
+// validateNamespaceSegment returns a ParseError if the token is meant as an entity namespace segment but starts
+// with a digit, which the scanner reads as a long rather than an identifier.
+func (p *Parser) validateNamespaceSegment(tok Token, lit string) error {
+	if tok == LONG {
+		pos := p.buf.pos
+		if next, rest := p.scan(); next == IDENT {
+			lit += rest
+		}
+		return &ParseError{Pos: pos, Msg: fmt.Sprintf("invalid entity namespace %q, namespaces must start with a letter", lit)}
+	}
+	return nil
+}
+
+// validateNamespaceSeparator returns a ParseError if the token following an entity namespace segment continues it
+// with a hyphen, which is not permitted within namespaces.
+func (p *Parser) validateNamespaceSeparator(segment string, tok Token, lit string) error {
+	if tok == DASH || (tok == LONG && strings.HasPrefix(lit, "-")) {
+		pos := p.buf.pos
+		if next, rest := p.scan(); next == IDENT {
+			lit += rest
+		}
+		return &ParseError{Pos: pos, Msg: fmt.Sprintf("invalid entity namespace %q, namespaces may only contain letters, digits and underscores", segment+lit)}
+	}
+	return nil
+}
+
+// errorf returns a ParseError positioned at the last read token.
+func (p *Parser) errorf(format string, a ...interface{}) error {
+	return &ParseError{Pos: p.buf.pos, Msg: fmt.Sprintf(format, a...)}
+}
+
 // unscan pushes the previously read token back onto the buffer.
 func (p *Parser) unscan() { p.buf.n = 1 }
@@ -1,6 +1,7 @@
 package polai_test
 
 import (
+	"errors"
 	"reflect"
 	"strings"
 	"testing"
@@ -12,7 +13,7 @@ import (
 func TestParser_ParseStatement(t *testing.T) {
 	var tests = []struct {
 		s     string
-		stmts *[]polai.PolicyStatement
+		stmts polai.PolicySet
 		err   string
 	}{
 		// Basic permit
@@ -23,7 +24,7 @@ func TestParser_ParseStatement(t *testing.T) {
 				action,
 				resource
 			);`,
-			stmts: &[]polai.PolicyStatement{
+			stmts: polai.PolicySet{
 				{
 					Effect:       polai.PERMIT,
 					AnyPrincipal: true,
@@ -41,7 +42,7 @@ func TestParser_ParseStatement(t *testing.T) {
 				action,
 				resource
 			);`,
-			stmts: &[]polai.PolicyStatement{
+			stmts: polai.PolicySet{
 				{
 					Effect:       polai.FORBID,
 					AnyPrincipal: true,
@@ -64,7 +65,7 @@ func TestParser_ParseStatement(t *testing.T) {
 				action,
 				resource
 			);`,
-			stmts: &[]polai.PolicyStatement{
+			stmts: polai.PolicySet{
 				{
 					Effect:       polai.PERMIT,
 					AnyPrincipal: true,
@@ -90,7 +91,7 @@ func TestParser_ParseStatement(t *testing.T) {
 				action,
 				resource // comment stuff
 			); // comment stuff`,
-			stmts: &[]polai.PolicyStatement{
+			stmts: polai.PolicySet{
 				{
 					Effect:       polai.PERMIT,
 					AnyPrincipal: true,
@@ -108,7 +109,7 @@ func TestParser_ParseStatement(t *testing.T) {
 				action == Namespace2::"Identifier2",
 				resource == Namespace3::"Identifier3"
 			);`,
-			stmts: &[]polai.PolicyStatement{
+			stmts: polai.PolicySet{
 				{
 					Effect:       polai.PERMIT,
 					Principal:    "Namespace::\"Identifier\"",
@@ -129,7 +130,7 @@ func TestParser_ParseStatement(t *testing.T) {
 				action in [ Namespace::"Identifier", Namespace2::"Identifier2" ],
 				resource
 			);`,
-			stmts: &[]polai.PolicyStatement{
+			stmts: polai.PolicySet{
 				{
 					Effect:        polai.PERMIT,
 					ActionParents: []string{"Namespace::\"Identifier\"", "Namespace2::\"Identifier2\""},
@@ -148,7 +149,7 @@ func TestParser_ParseStatement(t *testing.T) {
 				action in Namespace2::"Identifier2",
 				resource in Namespace3::"Identifier3"
 			);`,
-			stmts: &[]polai.PolicyStatement{
+			stmts: polai.PolicySet{
 				{
 					Effect:          polai.PERMIT,
 					PrincipalParent: "Namespace::\"Identifier\"",
@@ -171,7 +172,7 @@ func TestParser_ParseStatement(t *testing.T) {
 			) when {
 				123 == 0123
 			};`,
-			stmts: &[]polai.PolicyStatement{
+			stmts: polai.PolicySet{
 				{
 					Effect:       polai.PERMIT,
 					AnyPrincipal: true,
@@ -191,8 +192,255 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// Annotations
+		{
+			s: `
+			@id("policy1")
+			@advice("say \"hi\"\n")
+			permit (principal, action, resource);
+			forbid (principal, action, resource);`,
+			stmts: polai.PolicySet{
+				{
+					Effect:       polai.PERMIT,
+					AnyPrincipal: true,
+					AnyAction:    true,
+					AnyResource:  true,
+					Annotations: map[string]string{
+						"id":     "policy1",
+						"advice": "say \"hi\"\n",
+					},
+				},
+				{
+					Effect:       polai.FORBID,
+					AnyPrincipal: true,
+					AnyAction:    true,
+					AnyResource:  true,
+				},
+			},
+		},
+
+		// Scope type checks
+		{
+			s: `permit (principal is Org::User, action, resource);`,
+			stmts: polai.PolicySet{
+				{
+					Effect:        polai.PERMIT,
+					PrincipalType: "Org::User",
+					AnyPrincipal:  false,
+					AnyAction:     true,
+					AnyResource:   true,
+				},
+			},
+		},
+
+		{
+			s: `permit (principal, action, resource is Photo);`,
+			stmts: polai.PolicySet{
+				{
+					Effect:       polai.PERMIT,
+					ResourceType: "Photo",
+					AnyPrincipal: true,
+					AnyAction:    true,
+					AnyResource:  false,
+				},
+			},
+		},
+
+		{
+			s: `permit (principal, action is MyApp::Action, resource);`,
+			stmts: polai.PolicySet{
+				{
+					Effect:       polai.PERMIT,
+					ActionType:   "MyApp::Action",
+					AnyPrincipal: true,
+					AnyAction:    false,
+					AnyResource:  true,
+				},
+			},
+		},
+
+		// Let bindings
+		{
+			s: `permit (principal, action, resource) when { let x = context.a; let x = x + 1; x > 1 };`,
+			stmts: polai.PolicySet{
+				{
+					Effect:       polai.PERMIT,
+					AnyPrincipal: true,
+					AnyAction:    true,
+					AnyResource:  true,
+					Conditions: []polai.ConditionClause{
+						{
+							Type: polai.WHEN,
+							Sequence: []polai.SequenceItem{
+								{Token: polai.LETIDENT, Literal: "x", Normalized: "1"},
+								{Token: polai.GT, Literal: ">", Normalized: ">"},
+								{Token: polai.LONG, Literal: "1", Normalized: "1"},
+							},
+							Bindings: []polai.LetBinding{
+								{
+									Name: "x",
+									Sequence: []polai.SequenceItem{
+										{Token: polai.CONTEXT, Literal: "context", Normalized: "context"},
+										{Token: polai.PERIOD, Literal: ".", Normalized: "."},
+										{Token: polai.ATTRIBUTE, Literal: "a", Normalized: "a"},
+									},
+								},
+								{
+									Name: "x",
+									Sequence: []polai.SequenceItem{
+										{Token: polai.LETIDENT, Literal: "x", Normalized: "0"},
+										{Token: polai.PLUS, Literal: "+", Normalized: "+"},
+										{Token: polai.LONG, Literal: "1", Normalized: "1"},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+
+		// Bracket attribute access
+		{
+			s: `permit (principal, action, resource) when { context["key with spaces"].x[ "a\tb" ] in [1] };`,
+			stmts: polai.PolicySet{
+				{
+					Effect:       polai.PERMIT,
+					AnyPrincipal: true,
+					AnyAction:    true,
+					AnyResource:  true,
+					Conditions: []polai.ConditionClause{
+						{
+							Type: polai.WHEN,
+							Sequence: []polai.SequenceItem{
+								{Token: polai.CONTEXT, Literal: "context", Normalized: "context"},
+								{Token: polai.PERIOD, Literal: ".", Normalized: "."},
+								{Token: polai.ATTRIBUTE, Literal: `"key with spaces"`, Normalized: "key with spaces"},
+								{Token: polai.PERIOD, Literal: ".", Normalized: "."},
+								{Token: polai.ATTRIBUTE, Literal: "x", Normalized: "x"},
+								{Token: polai.PERIOD, Literal: ".", Normalized: "."},
+								{Token: polai.ATTRIBUTE, Literal: `"a\tb"`, Normalized: "a\tb"},
+								{Token: polai.IN, Literal: "in", Normalized: "in"},
+								{Token: polai.LEFT_SQB, Literal: "[", Normalized: "["},
+								{Token: polai.LONG, Literal: "1", Normalized: "1"},
+								{Token: polai.RIGHT_SQB, Literal: "]", Normalized: "]"},
+							},
+						},
+					},
+				},
+			},
+		},
+
+		// Unicode identifiers
+		{
+			s: `permit (principal == Département::"Archives", action, resource in Région::Île::"Nord");`,
+			stmts: polai.PolicySet{
+				{
+					Effect:         polai.PERMIT,
+					Principal:      `Département::"Archives"`,
+					ResourceParent: `Région::Île::"Nord"`,
+					AnyPrincipal:   false,
+					AnyAction:      true,
+					AnyResource:    false,
+				},
+			},
+		},
+
+		// Multi-segment namespaces
+		{
+			s: `permit (principal == Org_1::Sub_Unit::User::"alice", action, resource);`,
+			stmts: polai.PolicySet{
+				{
+					Effect:       polai.PERMIT,
+					Principal:    `Org_1::Sub_Unit::User::"alice"`,
+					AnyPrincipal: false,
+					AnyAction:    true,
+					AnyResource:  true,
+				},
+			},
+		},
+
+		// Template slots
+		{
+			s: `
+			permit (
+				principal == ?principal,
+				action,
+				resource in ?resource
+			);`,
+			stmts: polai.PolicySet{
+				{
+					Effect:         polai.PERMIT,
+					Principal:      "?principal",
+					ResourceParent: "?resource",
+					AnyPrincipal:   false,
+					AnyAction:      true,
+					AnyResource:    false,
+				},
+			},
+		},
+
+		// Unary minus
+		{
+			s: `permit (principal, action, resource) when { -(1) - -context.i == 2 };`,
+			stmts: polai.PolicySet{
+				{
+					Effect:       polai.PERMIT,
+					AnyPrincipal: true,
+					AnyAction:    true,
+					AnyResource:  true,
+					Conditions: []polai.ConditionClause{
+						{
+							Type: polai.WHEN,
+							Sequence: []polai.SequenceItem{
+								{Token: polai.UNARYMINUS, Literal: "-", Normalized: "-"},
+								{Token: polai.LEFT_PAREN, Literal: "(", Normalized: "("},
+								{Token: polai.LONG, Literal: "1", Normalized: "1"},
+								{Token: polai.RIGHT_PAREN, Literal: ")", Normalized: ")"},
+								{Token: polai.DASH, Literal: "-", Normalized: "-"},
+								{Token: polai.UNARYMINUS, Literal: "-", Normalized: "-"},
+								{Token: polai.CONTEXT, Literal: "context", Normalized: "context"},
+								{Token: polai.PERIOD, Literal: ".", Normalized: "."},
+								{Token: polai.ATTRIBUTE, Literal: "i", Normalized: "i"},
+								{Token: polai.EQUALITY, Literal: "==", Normalized: "=="},
+								{Token: polai.LONG, Literal: "2", Normalized: "2"},
+							},
+						},
+					},
+				},
+			},
+		},
+
 		// Errors
-		{s: `foo`, err: `found "foo", expected permit or forbid`},
+		{s: `permit (principal is User::"alice", action, resource);`, err: `found "\"alice\"", expected entity type at line 1, column 28`},
+		{s: `permit (principal is User in Group::"a", action, resource);`, err: `principal is cannot be combined with in within the scope at line 1, column 27`},
+		{s: `permit (principal, action, resource is Photo in Album::"a");`, err: `resource is cannot be combined with in within the scope at line 1, column 46`},
+		{s: `permit (principal, action, resource in Album::"a" is Photo);`, err: `found "is", expected right parentheses at line 1, column 51`},
+		{s: `permit (principal, action is Action in [Action::"a"], resource);`, err: `action is cannot be combined with in within the scope at line 1, column 37`},
+		{s: `permit (principal, action in [Action::"a"] is Action, resource);`, err: `found "is", expected comma at line 1, column 44`},
+		{s: `permit (principal, action, resource) when { let x = x + 1; x };`, err: `let binding "x" cannot refer to itself at line 1, column 54`},
+		{s: `permit (principal, action, resource) when { let x = 1 };`, err: `found "}", expected semicolon at line 1, column 55`},
+		{s: `permit (principal, action, resource) when { let x == 1; x };`, err: `found "==", expected assignment at line 1, column 51`},
+		{s: `permit (principal, action, resource) when { let x = ; true };`, err: `let binding "x" has no value at line 1, column 53`},
+		{s: `permit (principal, action, resource) when { true && y };`, err: `found " ", expected namespace separator at line 1, column 54`},
+		{s: `permit (principal, action, resource) when { context[key] };`, err: `found "key", expected double quoted string at line 1, column 53`},
+		{s: `permit (principal, action, resource) when { context["key" };`, err: `found "}", expected right square bracket at line 1, column 59`},
+		{s: `permit (principal like User, action, resource);`, err: `found "like", expected comma, equality operator, in, or is at line 1, column 19`},
+		{s: `@id "policy1" permit (principal, action, resource);`, err: `found "\"policy1\"", expected left parentheses at line 1, column 5`},
+		{s: `@id("policy1" permit (principal, action, resource);`, err: `found "permit", expected right parentheses at line 1, column 15`},
+		{s: `@("policy1") permit (principal, action, resource);`, err: `found "(", expected annotation name at line 1, column 2`},
+		{s: `@id(policy1) permit (principal, action, resource);`, err: `found "policy1", expected double quoted string at line 1, column 5`},
+		{s: `@id("a") @id("b") permit (principal, action, resource);`, err: `duplicate annotation "id" at line 1, column 10`},
+		{s: `permit (principal == Org::123::"alice", action, resource);`, err: `invalid entity namespace "123", namespaces must start with a letter at line 1, column 27`},
+		{s: `permit (principal == Org-Unit::"alice", action, resource);`, err: `invalid entity namespace "Org-Unit", namespaces may only contain letters, digits and underscores at line 1, column 25`},
+		{s: `permit (principal, action, resource) when { principal in Org::9Team::"a" };`, err: `invalid entity namespace "9Team", namespaces must start with a letter at line 1, column 63`},
+		{s: `permit (principal, action, resource) when { principal in Org::Sub-Team::"a" };`, err: `invalid entity namespace "Sub-Team", namespaces may only contain letters, digits and underscores at line 1, column 66`},
+		{s: `permit (principal, action, resource) when { principal == A-B::"x" };`, err: `invalid entity namespace "A-B", namespaces may only contain letters, digits and underscores at line 1, column 59`},
+		{s: `permit (principal, action, resource) when { principal == 1A::"x" };`, err: `invalid entity namespace "1A", namespaces must start with a letter at line 1, column 59`},
+
+		{s: `foo`, err: `found "foo", expected permit or forbid at line 1, column 1`},
+		{s: `permit (principal == ?resource, action, resource);`, err: `found "?resource", expected entity or ?principal at line 1, column 22`},
+		{s: "permit (\n\tprincipal,\n\taction,\n\tresource\n) when {\n\tcontext.foo == #\n};", err: `unexpected token found in condition clause "#" (ILLEGAL) at line 6, column 17`},
 	}
 
 	for i, tt := range tests {
@@ -212,3 +460,107 @@ func errstring(err error) string {
 	}
 	return ""
 }
+
+// Ensure condition clauses can be filtered by type.
+func TestParser_ConditionClauses(t *testing.T) {
+	stmts, err := polai.NewParser(strings.NewReader(`
+	permit (
+		principal,
+		action,
+		resource
+	) when {
+		true
+	} unless {
+		false
+	} when {
+		1 == 1
+	};`)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	stmt := stmts[0]
+	if len(stmt.WhenClauses()) != 2 {
+		t.Errorf("when clause count mismatch: exp=2 got=%d", len(stmt.WhenClauses()))
+	}
+	if len(stmt.UnlessClauses()) != 1 {
+		t.Errorf("unless clause count mismatch: exp=1 got=%d", len(stmt.UnlessClauses()))
+	}
+	if stmt.Conditions[0].Type != polai.WHEN || stmt.Conditions[1].Type != polai.UNLESS {
+		t.Errorf("condition order mismatch: got=%v, %v", stmt.Conditions[0].Type, stmt.Conditions[1].Type)
+	}
+	if !reflect.DeepEqual(stmt.WhenClauses()[1], stmt.Conditions[2]) {
+		t.Errorf("when clause mismatch: exp=%#v got=%#v", stmt.Conditions[2], stmt.WhenClauses()[1])
+	}
+	if len(stmt.Conditions) != 3 {
+		t.Errorf("conditions were modified: got=%d", len(stmt.Conditions))
+	}
+	for i, exp := range []string{"when { true }", "unless { false }", "when { 1 == 1 }"} {
+		if got := stmt.Conditions[i].ToString(); got != exp {
+			t.Errorf("%d. string mismatch: exp=%s got=%s", i, exp, got)
+		}
+	}
+}
+
+// Ensure strict parsing rejects statements that deny all requests.
+func TestParser_StrictParsing(t *testing.T) {
+	var tests = []struct {
+		s      string
+		strict bool
+		err    string
+	}{
+		{s: `forbid (principal, action, resource);`},
+		{s: `forbid (principal, action, resource);`, strict: true, err: `forbid statement has no scope restrictions or conditions and denies all requests at line 1, column 37`},
+		{s: `forbid (principal, action, resource) when { true };`, strict: true},
+		{s: `forbid (principal == User::"alice", action, resource);`, strict: true},
+		{s: `permit (principal, action, resource);`, strict: true},
+	}
+
+	for i, tt := range tests {
+		var opts []polai.Option
+		if tt.strict {
+			opts = append(opts, polai.WithStrictParsing())
+		}
+		_, err := polai.NewParser(strings.NewReader(tt.s), opts...).Parse()
+		if !reflect.DeepEqual(tt.err, errstring(err)) {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.err, err)
+		}
+	}
+}
+
+// Ensure parsing continues after a syntax error so that every error is reported.
+func TestParser_MultipleErrors(t *testing.T) {
+	stmts, err := polai.NewParser(strings.NewReader(`
+permit (principal, action, resource);
+permit (principal == "alice", action, resource);
+forbid (principal, action, resource) when { context.a == # };
+permit (principal, action, resource is User::"bob");
+forbid (principal, action, resource) unless { context.b };
+foo`)).Parse()
+
+	exp := `found "\"alice\"", expected entity namespace at line 3, column 22; ` +
+		`unexpected token found in condition clause "#" (ILLEGAL) at line 4, column 58; ` +
+		`found "\"bob\"", expected entity type at line 5, column 46; ` +
+		`found "foo", expected permit or forbid at line 7, column 1`
+	if errstring(err) != exp {
+		t.Errorf("error mismatch:\n  exp=%s\n  got=%s", exp, err)
+	}
+
+	var parseErrs polai.ParseErrors
+	if !errors.As(err, &parseErrs) {
+		t.Fatalf("expected ParseErrors, got %T", err)
+	}
+	if len(parseErrs) != 4 {
+		t.Errorf("error count mismatch: exp=4 got=%d", len(parseErrs))
+	}
+	if parseErrs[1].Line != 4 || parseErrs[1].Col != 58 {
+		t.Errorf("position mismatch: exp=4:58 got=%d:%d", parseErrs[1].Line, parseErrs[1].Col)
+	}
+
+	if stmts == nil || len(stmts) != 2 {
+		t.Fatalf("expected 2 statements to be parsed, got %v", stmts)
+	}
+	if stmts[0].Effect != polai.PERMIT || stmts[1].Effect != polai.FORBID {
+		t.Errorf("statement mismatch: got=%v, %v", stmts[0].Effect, stmts[1].Effect)
+	}
+}
@@ -0,0 +1,84 @@
+package polai
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PolicySet represents the statements of one or more policies, in the order they were parsed.
+type PolicySet []PolicyStatement
+
+// FilterByEffect returns the statements with the provided effect, either PERMIT or FORBID.
+func (ps PolicySet) FilterByEffect(effect Token) PolicySet {
+	filtered := PolicySet{}
+	for _, stmt := range ps {
+		if stmt.Effect == effect {
+			filtered = append(filtered, stmt)
+		}
+	}
+
+	return filtered
+}
+
+// Count returns the number of statements.
+func (ps PolicySet) Count() int {
+	return len(ps)
+}
+
+// HasPermit returns whether any statement is a permit statement.
+func (ps PolicySet) HasPermit() bool {
+	return len(ps.FilterByEffect(PERMIT)) > 0
+}
+
+// HasForbid returns whether any statement is a forbid statement.
+func (ps PolicySet) HasForbid() bool {
+	return len(ps.FilterByEffect(FORBID)) > 0
+}
+
+// Append returns a new set containing the statements of ps followed by those of stmts. Neither set is modified.
+func (ps PolicySet) Append(stmts PolicySet) PolicySet {
+	appended := make(PolicySet, 0, len(ps)+len(stmts))
+	appended = append(appended, ps...)
+
+	return append(appended, stmts...)
+}
+
+// Validate performs static semantic checks on the statements, returning every problem found as a ValidationError.
+func (ps PolicySet) Validate() []error {
+	var errs []error
+	ids := map[string]struct{}{}
+
+	for i, stmt := range ps {
+		for _, action := range append([]string{stmt.Action}, stmt.ActionParents...) {
+			if action == "" {
+				continue
+			}
+			if actionType := entityType(action); actionType != "Action" && !strings.HasSuffix(actionType, "::Action") {
+				errs = append(errs, &ValidationError{StatementIndex: i, Msg: fmt.Sprintf("action %s is not within the Action namespace", action)})
+			}
+		}
+		if stmt.Principal != "" && stmt.PrincipalParent != "" {
+			errs = append(errs, &ValidationError{StatementIndex: i, Msg: "principal cannot be both equal to and in an entity"})
+		}
+		if stmt.Resource != "" && stmt.ResourceParent != "" {
+			errs = append(errs, &ValidationError{StatementIndex: i, Msg: "resource cannot be both equal to and in an entity"})
+		}
+		for _, cond := range stmt.Conditions {
+			if len(cond.Sequence) == 0 {
+				condType := "when"
+				if cond.Type == UNLESS {
+					condType = "unless"
+				}
+				errs = append(errs, &ValidationError{StatementIndex: i, Msg: fmt.Sprintf("empty %s block", condType)})
+			}
+		}
+		if id, ok := stmt.Annotations["id"]; ok {
+			if _, ok := ids[id]; ok {
+				errs = append(errs, &ValidationError{StatementIndex: i, Msg: fmt.Sprintf("duplicate @id %q", id)})
+			}
+			ids[id] = struct{}{}
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,124 @@
+package polai_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/iann0036/polai"
+)
+
+// Ensure policy sets report and filter their statements.
+func TestPolicySet(t *testing.T) {
+	ps, err := polai.NewParser(strings.NewReader(`
+	permit (principal, action, resource);
+	forbid (principal == User::"mallory", action, resource);
+	permit (principal, action == Action::"view", resource);`)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if exp, got := 3, ps.Count(); exp != got {
+		t.Errorf("count mismatch: exp=%d got=%d", exp, got)
+	}
+	if !ps.HasPermit() || !ps.HasForbid() {
+		t.Errorf("expected permit and forbid statements, got permit=%v forbid=%v", ps.HasPermit(), ps.HasForbid())
+	}
+
+	permits := ps.FilterByEffect(polai.PERMIT)
+	if exp, got := 2, permits.Count(); exp != got {
+		t.Errorf("permit count mismatch: exp=%d got=%d", exp, got)
+	}
+	if permits.HasForbid() {
+		t.Errorf("expected no forbid statements after filtering permits")
+	}
+	if permits[1].Action != `Action::"view"` {
+		t.Errorf("expected filtered statements to keep their order, got %#v", permits)
+	}
+	if forbids := ps.FilterByEffect(polai.FORBID); forbids.Count() != 1 || forbids.HasPermit() {
+		t.Errorf("expected a single forbid statement, got %#v", forbids)
+	}
+
+	appended := permits.Append(ps.FilterByEffect(polai.FORBID))
+	if exp, got := 3, appended.Count(); exp != got {
+		t.Errorf("appended count mismatch: exp=%d got=%d", exp, got)
+	}
+	if appended[2].Effect != polai.FORBID || permits.Count() != 2 {
+		t.Errorf("expected append to add statements to a new set, got %#v", appended)
+	}
+
+	var empty polai.PolicySet
+	if empty.Count() != 0 || empty.HasPermit() || empty.HasForbid() {
+		t.Errorf("expected empty policy set to have no statements")
+	}
+}
+
+// Ensure static validation reports every semantic problem in a policy set.
+func TestPolicySet_Validate(t *testing.T) {
+	var tests = []struct {
+		s    string
+		errs []string
+	}{
+		{
+			s: `
+			@id("read")
+			permit (principal in Group::"admins", action in [Action::"view", Ns::Action::"edit"], resource) when { true };
+			@id("write")
+			forbid (principal, action == Action::"delete", resource) unless { principal == User::"admin" };`,
+		},
+		{
+			s: `permit (principal, action == Photo::"view", resource);
+			permit (principal, action in [Action::"view", Ns::Photo::"edit"], resource);`,
+			errs: []string{
+				`action Photo::"view" is not within the Action namespace in statement 0`,
+				`action Ns::Photo::"edit" is not within the Action namespace in statement 1`,
+			},
+		},
+		{
+			s: `permit (principal, action, resource) when {} unless {};`,
+			errs: []string{
+				"empty when block in statement 0",
+				"empty unless block in statement 0",
+			},
+		},
+		{
+			s: `
+			@id("a")
+			permit (principal, action, resource);
+			@id("b")
+			permit (principal, action, resource);
+			@id("a")
+			forbid (principal, action, resource) when {};`,
+			errs: []string{
+				"empty when block in statement 2",
+				`duplicate @id "a" in statement 2`,
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		ps, err := polai.NewParser(strings.NewReader(tt.s)).Parse()
+		if err != nil {
+			t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+		}
+
+		var errs []string
+		for _, err := range ps.Validate() {
+			var validationErr *polai.ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Errorf("%d. expected ValidationError, got %T", i, err)
+			}
+			errs = append(errs, err.Error())
+		}
+		if !reflect.DeepEqual(tt.errs, errs) {
+			t.Errorf("%d. %q: errors mismatch:\n  exp=%q\n  got=%q\n\n", i, tt.s, tt.errs, errs)
+		}
+	}
+
+	// both fields are never set by the parser, but may be when statements are constructed directly
+	ps := polai.PolicySet{{Effect: polai.PERMIT, Principal: `User::"a"`, PrincipalParent: `Group::"b"`, Resource: `Photo::"c"`, ResourceParent: `Album::"d"`, AnyAction: true}}
+	if exp, got := 2, len(ps.Validate()); exp != got {
+		t.Errorf("error count mismatch for conflicting scope: exp=%d got=%d", exp, got)
+	}
+}
@@ -4,20 +4,38 @@ import (
 	"bufio"
 	"bytes"
 	"io"
+	"strconv"
+	"unicode"
 )
 
 // Scanner represents a lexical scanner.
 type Scanner struct {
-	r *bufio.Reader
+	r   *bufio.Reader
+	pos Pos // position of the next rune
+	prv Pos // position before the last read, restored on unread
+	tok Pos // position of the first rune of the last scanned token
+}
+
+// Pos represents a line and column within the scanned source, both starting from 1.
+type Pos struct {
+	Line int
+	Col  int
 }
 
 // NewScanner returns a new instance of Scanner.
 func NewScanner(r io.Reader) *Scanner {
-	return &Scanner{r: bufio.NewReader(r)}
+	return &Scanner{r: bufio.NewReader(r), pos: Pos{Line: 1, Col: 1}, tok: Pos{Line: 1, Col: 1}}
+}
+
+// Pos returns the line and column at which the last scanned token started.
+func (s *Scanner) Pos() (line, col int) {
+	return s.tok.Line, s.tok.Col
 }
 
 // Scan returns the next token and literal value.
 func (s *Scanner) Scan() (tok Token, lit string) {
+	s.tok = s.pos
+
 	// Read the next rune.
 	ch := s.read()
 	lit = string(ch)
@@ -28,17 +46,12 @@ func (s *Scanner) Scan() (tok Token, lit string) {
 	if isWhitespace(ch) {
 		s.unread()
 		return s.scanWhitespace()
-	} else if isLetter(ch) {
+	} else if isLetter(ch) || ch == '_' {
 		s.unread()
 		return s.scanIdent()
 	} else if isDigit(ch) {
-		ch = s.read()
-		for isDigit(ch) {
-			lit += string(ch)
-			ch = s.read()
-		}
 		s.unread()
-		return LONG, lit
+		return s.scanNumber()
 	}
 
 	// Otherwise read the individual character.
@@ -65,8 +78,12 @@ func (s *Scanner) Scan() (tok Token, lit string) {
 		return PLUS, lit
 	case '*':
 		return MULTIPLIER, lit
+	case '%':
+		return PERCENT, lit
 	case '.':
 		return PERIOD, lit
+	case '@':
+		return ATSIGN, lit
 	case '<':
 		ch = s.read()
 		if ch == '=' {
@@ -85,19 +102,15 @@ func (s *Scanner) Scan() (tok Token, lit string) {
 
 		return GT, lit
 	case '-':
+		// Only negative longs are scanned here. There is no decimal literal, so negative decimals
+		// must be written with the sign inside the string, e.g. decimal("-1.5").
 		ch = s.read()
+		s.unread()
 		if !isDigit(ch) {
-			s.unread()
 			return DASH, lit
 		}
-		lit += string(ch)
-		ch = s.read()
-		for isDigit(ch) {
-			lit += string(ch)
-			ch = s.read()
-		}
-		s.unread()
-		return LONG, lit
+		tok, num := s.scanNumber()
+		return tok, lit + num
 	case '"':
 		for {
 			ch = s.read()
@@ -113,13 +126,18 @@ func (s *Scanner) Scan() (tok Token, lit string) {
 				return ILLEGAL, lit
 			}
 		}
+		if _, err := unquoteString(lit); err != nil {
+			return ILLEGAL, lit
+		}
 		return DBLQUOTESTR, lit
 	case '=':
 		ch = s.read()
-		lit += string(ch)
 		if ch == '=' {
+			lit += string(ch)
 			return EQUALITY, lit
 		}
+		s.unread()
+		return ASSIGN, lit
 	case '!':
 		ch = s.read()
 		if ch == '=' {
@@ -148,6 +166,15 @@ func (s *Scanner) Scan() (tok Token, lit string) {
 		if ch == '|' {
 			return OR, lit
 		}
+	case '?':
+		ch = s.read()
+		if !isLetter(ch) {
+			s.unread()
+			return ILLEGAL, lit
+		}
+		s.unread()
+		_, ident := s.scanIdent()
+		return SLOT, lit + ident
 	case '/':
 		ch = s.read()
 		lit += string(ch)
@@ -159,7 +186,25 @@ func (s *Scanner) Scan() (tok Token, lit string) {
 			}
 			s.unread()
 			return COMMENT, lit
+		} else if ch == '*' {
+			// block comments are not nested, the first */ ends the comment
+			for {
+				ch = s.read()
+				if ch == eof {
+					return ILLEGAL, lit
+				}
+				lit += string(ch)
+				if ch == '*' {
+					if ch = s.read(); ch == '/' {
+						lit += string(ch)
+						return COMMENT, lit
+					}
+					s.unread()
+				}
+			}
 		}
+		s.unread()
+		return SLASH, "/"
 	}
 
 	return ILLEGAL, lit
@@ -198,7 +243,7 @@ func (s *Scanner) scanIdent() (tok Token, lit string) {
 	for {
 		if ch := s.read(); ch == eof {
 			break
-		} else if !isLetter(ch) && !isDigit(ch) && ch != '_' {
+		} else if !isLetter(ch) && !unicode.IsDigit(ch) && ch != '_' {
 			s.unread()
 			break
 		} else {
@@ -220,8 +265,12 @@ func (s *Scanner) scanIdent() (tok Token, lit string) {
 		return RESOURCE, buf.String()
 	case "context":
 		return CONTEXT, buf.String()
+	case "let":
+		return LETBIND, buf.String()
 	case "in":
 		return IN, buf.String()
+	case "is":
+		return IS, buf.String()
 	case "when":
 		return WHEN, buf.String()
 	case "unless":
@@ -246,27 +295,105 @@ func (s *Scanner) scanIdent() (tok Token, lit string) {
 	return IDENT, buf.String()
 }
 
+// scanNumber consumes a decimal, hexadecimal (0x) or octal (0o) long, with optional underscore
+// separators between digits. Hexadecimal and octal literals are converted to their base-10 representation.
+func (s *Scanner) scanNumber() (tok Token, lit string) {
+	ch := s.read()
+	lit = string(ch)
+
+	base := 10
+	digits := lit
+	if ch == '0' {
+		ch = s.read()
+		switch ch {
+		case 'x', 'X':
+			base = 16
+		case 'o', 'O':
+			base = 8
+		default:
+			s.unread()
+		}
+		if base != 10 {
+			lit += string(ch)
+			digits = ""
+		}
+	}
+
+	// Underscores may separate digits but are stripped from the result.
+	invalid, sep := false, false
+	for {
+		ch = s.read()
+		if ch == '_' {
+			invalid = invalid || sep || digits == ""
+			sep = true
+		} else if isDigitInBase(ch, base) {
+			sep = false
+			digits += string(ch)
+		} else {
+			s.unread()
+			break
+		}
+		lit += string(ch)
+	}
+
+	if invalid || sep {
+		return ILLEGAL, lit
+	}
+	if base == 10 {
+		return LONG, digits
+	}
+
+	i, err := strconv.ParseInt(digits, base, 64)
+	if err != nil {
+		return ILLEGAL, lit
+	}
+	return LONG, strconv.FormatInt(i, 10)
+}
+
 // read reads the next rune from the buffered reader.
 // Returns the rune(0) if an error occurs (or io.EOF is returned).
 func (s *Scanner) read() rune {
+	s.prv = s.pos
 	ch, _, err := s.r.ReadRune()
 	if err != nil {
 		return eof
 	}
+	if ch == '\n' {
+		s.pos.Line++
+		s.pos.Col = 1
+	} else {
+		s.pos.Col++
+	}
 	return ch
 }
 
 // unread places the previously read rune back on the reader.
-func (s *Scanner) unread() { _ = s.r.UnreadRune() }
+func (s *Scanner) unread() {
+	if s.r.UnreadRune() == nil {
+		s.pos = s.prv
+	}
+}
 
-// isWhitespace returns true if the rune is a space, tab, or newline.
-func isWhitespace(ch rune) bool { return ch == ' ' || ch == '\t' || ch == '\n' }
+// isWhitespace returns true if the rune is a space, tab, carriage return, or newline.
+// This only applies between tokens; double-quoted strings retain all characters.
+func isWhitespace(ch rune) bool { return ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n' }
 
-// isLetter returns true if the rune is a letter.
-func isLetter(ch rune) bool { return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') }
+// isLetter returns true if the rune is a letter, including non-ASCII letters.
+func isLetter(ch rune) bool { return unicode.IsLetter(ch) }
 
 // isDigit returns true if the rune is a digit.
 func isDigit(ch rune) bool { return (ch >= '0' && ch <= '9') }
 
+// isDigitInBase returns true if the rune is a digit in the given base (8, 10 or 16).
+func isDigitInBase(ch rune, base int) bool {
+	switch base {
+	case 8:
+		return ch >= '0' && ch <= '7'
+	case 16:
+		return isDigit(ch) || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+	}
+	return isDigit(ch)
+}
+
 // eof represents a marker rune for the end of the reader.
 var eof = rune(0)
@@ -20,17 +20,81 @@ func TestScanner_Scan(t *testing.T) {
 		{s: ` `, tok: polai.WHITESPC, lit: " "},
 		{s: "\t", tok: polai.WHITESPC, lit: "\t"},
 		{s: "\n", tok: polai.WHITESPC, lit: "\n"},
+		{s: "\r\n", tok: polai.WHITESPC, lit: "\r\n"},
+
+		// Comments
+		{s: `// abc`, tok: polai.COMMENT, lit: `// abc`},
+		{s: "/* abc\n def */ ghi", tok: polai.COMMENT, lit: "/* abc\n def */"},
+		{s: `/* a /* b */ c */`, tok: polai.COMMENT, lit: `/* a /* b */`},
+		{s: `/** abc **/`, tok: polai.COMMENT, lit: `/** abc **/`},
+		{s: `/* "*/" */`, tok: polai.COMMENT, lit: `/* "*/`},
+		{s: `/* abc`, tok: polai.ILLEGAL, lit: `/* abc`},
+		{s: `"/* abc */"`, tok: polai.DBLQUOTESTR, lit: `"/* abc */"`},
+		{s: `/`, tok: polai.SLASH, lit: `/`},
+		{s: `/2`, tok: polai.SLASH, lit: `/`},
+		{s: `%`, tok: polai.PERCENT, lit: `%`},
+
+		// Numbers
+		{s: `-123`, tok: polai.LONG, lit: `-123`},
+		{s: `-1.5`, tok: polai.LONG, lit: `-1`},
+		{s: `123`, tok: polai.LONG, lit: `123`},
+		{s: `0123`, tok: polai.LONG, lit: `0123`},
+		{s: `0xFF`, tok: polai.LONG, lit: `255`},
+		{s: `0Xff;`, tok: polai.LONG, lit: `255`},
+		{s: `0o755`, tok: polai.LONG, lit: `493`},
+		{s: `0O17 `, tok: polai.LONG, lit: `15`},
+		{s: `0o78`, tok: polai.LONG, lit: `7`},
+		{s: `0x`, tok: polai.ILLEGAL, lit: `0x`},
+		{s: `0o`, tok: polai.ILLEGAL, lit: `0o`},
+		{s: `0x8000000000000000`, tok: polai.ILLEGAL, lit: `0x8000000000000000`},
+		{s: `-0x10`, tok: polai.LONG, lit: `-16`},
+		{s: `1_000_000`, tok: polai.LONG, lit: `1000000`},
+		{s: `-1_000`, tok: polai.LONG, lit: `-1000`},
+		{s: `0xFF_FF`, tok: polai.LONG, lit: `65535`},
+		{s: `1_000_`, tok: polai.ILLEGAL, lit: `1_000_`},
+		{s: `1__000`, tok: polai.ILLEGAL, lit: `1__000`},
+		{s: `0x_FF`, tok: polai.ILLEGAL, lit: `0x_FF`},
+		{s: `_1000`, tok: polai.IDENT, lit: `_1000`},
+
+		// Strings
+		{s: "\"a\rb\"", tok: polai.DBLQUOTESTR, lit: "\"a\rb\""},
+		{s: "\"\r\n\"", tok: polai.DBLQUOTESTR, lit: "\"\r\n\""},
+		{s: `"abc"`, tok: polai.DBLQUOTESTR, lit: `"abc"`},
+		{s: `"a\"b"`, tok: polai.DBLQUOTESTR, lit: `"a\"b"`},
+		{s: `"a\\b"`, tok: polai.DBLQUOTESTR, lit: `"a\\b"`},
+		{s: `"\n\t\r\0"`, tok: polai.DBLQUOTESTR, lit: `"\n\t\r\0"`},
+		{s: `"\/\'\*"`, tok: polai.DBLQUOTESTR, lit: `"\/\'\*"`},
+		{s: `"\u00e9"`, tok: polai.DBLQUOTESTR, lit: `"\u00e9"`},
+		{s: `"\u{1F600}"`, tok: polai.DBLQUOTESTR, lit: `"\u{1F600}"`},
+		{s: `"\q"`, tok: polai.ILLEGAL, lit: `"\q"`},
+		{s: `"\u00g9"`, tok: polai.ILLEGAL, lit: `"\u00g9"`},
+		{s: `"\u12"`, tok: polai.ILLEGAL, lit: `"\u12"`},
+		{s: `"\u{}"`, tok: polai.ILLEGAL, lit: `"\u{}"`},
+		{s: `"\u{110000}"`, tok: polai.ILLEGAL, lit: `"\u{110000}"`},
+		{s: `"abc`, tok: polai.ILLEGAL, lit: "\"abc\x00"},
 
 		// Misc characters
 		{s: `,`, tok: polai.COMMA, lit: ","},
+		{s: `@`, tok: polai.ATSIGN, lit: "@"},
+		{s: `=`, tok: polai.ASSIGN, lit: "="},
+		{s: `==`, tok: polai.EQUALITY, lit: "=="},
 
 		// Identifiers
 		{s: `foo`, tok: polai.IDENT, lit: `foo`},
 		{s: `Zx12_3U_-`, tok: polai.IDENT, lit: `Zx12_3U_`},
+		{s: `Département::`, tok: polai.IDENT, lit: `Département`},
+		{s: `été`, tok: polai.IDENT, lit: `été`},
+		{s: `文件١٢`, tok: polai.IDENT, lit: `文件١٢`},
+
+		// Template slots
+		{s: `?principal`, tok: polai.SLOT, lit: `?principal`},
+		{s: `?`, tok: polai.ILLEGAL, lit: `?`},
 
 		// Keywords
 		{s: `permit`, tok: polai.PERMIT, lit: "permit"},
 		{s: `forbid`, tok: polai.FORBID, lit: "forbid"},
+		{s: `is`, tok: polai.IS, lit: "is"},
+		{s: `let`, tok: polai.LETBIND, lit: "let"},
 	}
 
 	for i, tt := range tests {
@@ -43,3 +107,32 @@ func TestScanner_Scan(t *testing.T) {
 		}
 	}
 }
+
+// Ensure the scanner reports the position of each token, including after lookahead.
+func TestScanner_Pos(t *testing.T) {
+	var tests = []struct {
+		s   string
+		pos [][2]int
+	}{
+		{s: ``, pos: [][2]int{{1, 1}}},
+		{s: `a<b`, pos: [][2]int{{1, 1}, {1, 2}, {1, 3}, {1, 4}}},
+		{s: `a<=b`, pos: [][2]int{{1, 1}, {1, 2}, {1, 4}, {1, 5}}},
+		{s: "foo\n  -bar", pos: [][2]int{{1, 1}, {1, 4}, {2, 3}, {2, 4}, {2, 7}}},
+		{s: "// x\r\n1 -2", pos: [][2]int{{1, 1}, {1, 6}, {2, 1}, {2, 2}, {2, 3}, {2, 5}}},
+		{s: "/* a\nb */x", pos: [][2]int{{1, 1}, {2, 5}, {2, 6}}},
+		{s: "\"a\nb\" ?c", pos: [][2]int{{1, 1}, {2, 3}, {2, 4}, {2, 6}}},
+	}
+
+	for i, tt := range tests {
+		s := polai.NewScanner(strings.NewReader(tt.s))
+		for j, exp := range tt.pos {
+			tok, _ := s.Scan()
+			if line, col := s.Pos(); line != exp[0] || col != exp[1] {
+				t.Errorf("%d. %q token %d (%v) position mismatch: exp=%d:%d got=%d:%d", i, tt.s, j, tok, exp[0], exp[1], line, col)
+			}
+		}
+		if tok, _ := s.Scan(); tok != polai.EOF {
+			t.Errorf("%d. %q expected EOF, got %v", i, tt.s, tok)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package polai
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const serializerIndent = "    "
+
+// Serialize converts policy statements back into Cedar policy text. Comments and the original formatting are
+// not retained, however the output parses back to the same statements.
+func Serialize(stmts []PolicyStatement) string {
+	var b strings.Builder
+
+	for i, stmt := range stmts {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(serializeStatement(stmt))
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+func serializeStatement(stmt PolicyStatement) string {
+	var b strings.Builder
+
+	keys := make([]string, 0, len(stmt.Annotations))
+	for key := range stmt.Annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, "@%s(%s)\n", key, quoteString(stmt.Annotations[key]))
+	}
+
+	if stmt.Effect == FORBID {
+		b.WriteString("forbid (\n")
+	} else {
+		b.WriteString("permit (\n")
+	}
+
+	b.WriteString(serializerIndent + "principal")
+	if stmt.Principal != "" {
+		b.WriteString(" == " + stmt.Principal)
+	} else if stmt.PrincipalParent != "" {
+		b.WriteString(" in " + stmt.PrincipalParent)
+	} else if stmt.PrincipalType != "" {
+		b.WriteString(" is " + stmt.PrincipalType)
+	}
+	b.WriteString(",\n")
+
+	b.WriteString(serializerIndent + "action")
+	if stmt.Action != "" {
+		b.WriteString(" == " + stmt.Action)
+	} else if stmt.ActionType != "" {
+		b.WriteString(" is " + stmt.ActionType)
+	} else if len(stmt.ActionParents) > 0 {
+		b.WriteString(" in [" + strings.Join(stmt.ActionParents, ", ") + "]")
+	}
+	b.WriteString(",\n")
+
+	b.WriteString(serializerIndent + "resource")
+	if stmt.Resource != "" {
+		b.WriteString(" == " + stmt.Resource)
+	} else if stmt.ResourceParent != "" {
+		b.WriteString(" in " + stmt.ResourceParent)
+	} else if stmt.ResourceType != "" {
+		b.WriteString(" is " + stmt.ResourceType)
+	}
+	b.WriteString("\n)")
+
+	for _, cond := range stmt.Conditions {
+		if cond.Type == UNLESS {
+			b.WriteString(" unless {")
+		} else {
+			b.WriteString(" when {")
+		}
+		for _, binding := range cond.Bindings {
+			b.WriteString("\n" + serializerIndent + "let " + binding.Name + " = " + serializeSequence(binding.Sequence) + ";")
+		}
+		if len(cond.Sequence) > 0 {
+			b.WriteString("\n" + serializerIndent + serializeSequence(cond.Sequence))
+		}
+		if len(cond.Bindings) > 0 || len(cond.Sequence) > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("}")
+	}
+	b.WriteString(";")
+
+	return b.String()
+}
+
+// serializeSequence converts a condition clause sequence back into infix text. Items are separated by a space,
+// except where the parser requires tokens to be adjacent (attribute access, function calls and record keys) or
+// where punctuation reads better without one.
+func serializeSequence(seq []SequenceItem) string {
+	var b strings.Builder
+
+	for i, item := range seq {
+		// bracket notation, e.g. context["key"], is parsed as a period followed by a quoted attribute
+		if item.Token == PERIOD && i+1 < len(seq) && isQuotedAttribute(seq[i+1]) {
+			continue
+		}
+		if isQuotedAttribute(item) {
+			b.WriteString("[" + item.Literal + "]")
+			continue
+		}
+
+		if i > 0 && !serializeAdjacent(seq[i-1], item) {
+			b.WriteString(" ")
+		}
+		b.WriteString(item.Literal)
+	}
+
+	return b.String()
+}
+
+func isQuotedAttribute(item SequenceItem) bool {
+	return item.Token == ATTRIBUTE && strings.HasPrefix(item.Literal, "\"")
+}
+
+func serializeAdjacent(prev SequenceItem, cur SequenceItem) bool {
+	switch prev.Token {
+	case PERIOD, LEFT_PAREN, LEFT_SQB, EXCLAMATION, UNARYMINUS:
+		return true
+	case FUNCTION:
+		return cur.Token == LEFT_PAREN
+	}
+
+	switch cur.Token {
+	case PERIOD, COMMA, COLON, RIGHT_PAREN, RIGHT_SQB:
+		return true
+	}
+
+	return false
+}
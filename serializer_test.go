@@ -0,0 +1,78 @@
+package polai_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/iann0036/polai"
+)
+
+// Ensure statements are serialized to canonical policy text.
+func TestSerialize(t *testing.T) {
+	stmts, err := polai.NewParser(strings.NewReader(`
+	@id("policy1") @advice("say \"hi\"")
+	permit(principal==User::"alice",action in [Action::"view",Action::"edit"],resource in Folder::"docs")
+	when{context.ssl&&resource.owner.contains(principal)} // owner only
+	unless { principal has suspended };
+	forbid (principal is User, action, resource) when { ip("10.0.0.1").isLoopback() || context.n - 1 > -1 };`)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	exp := `@advice("say \"hi\"")
+@id("policy1")
+permit (
+    principal == User::"alice",
+    action in [Action::"view", Action::"edit"],
+    resource in Folder::"docs"
+) when {
+    context.ssl && resource.owner.contains(principal)
+} unless {
+    principal has suspended
+};
+
+forbid (
+    principal is User,
+    action,
+    resource
+) when {
+    ip("10.0.0.1").isLoopback() || context.n - 1 > -1
+};
+`
+	if got := polai.Serialize(stmts); got != exp {
+		t.Errorf("serialize mismatch:\n\nexp=%s\n\ngot=%s", exp, got)
+	}
+}
+
+// Ensure serialized statements parse back to the same statements.
+func TestSerialize_RoundTrip(t *testing.T) {
+	var tests = []string{
+		`permit (principal, action, resource);`,
+		`forbid (principal in Group::"admins", action == Action::"delete", resource == Photo::"a.jpg");`,
+		`permit (principal == ?principal, action is MyApp::Action, resource in ?resource);`,
+		`permit (principal, action in Action::"read", resource is Org::Photo) when {};`,
+		`@id("a\tb\u{1}") permit (principal, action, resource) when { "\n\"\\" like "*\*" };`,
+		`permit (principal, action, resource) when { { "a": 1, b: [1, 2, -3], "c": { d: "e" } }.b.contains(2) };`,
+		`permit (principal, action, resource) when { if !context.a then 0x10 * 2 == 1_000 else !(1 < 2) };`,
+		`permit (principal, action, resource) when { decimal("1.23").lessThan(decimal("2.1")) && principal is Org::User };`,
+		`permit (principal, action, resource) when { principal.name like "a*" && resource in principal.groups } unless { context has "key" };`,
+		`permit (principal, action, resource) when { let x = context.a; let y = { x: x }; y.x == x };`,
+		`permit (principal, action, resource) when { context["a b"].c["\"d\""] == principal["1"] };`,
+	}
+
+	for i, s := range tests {
+		stmts, err := polai.NewParser(strings.NewReader(s)).Parse()
+		if err != nil {
+			t.Fatalf("%d. %q: unexpected error: %s", i, s, err)
+		}
+
+		serialized := polai.Serialize(stmts)
+		reparsed, err := polai.NewParser(strings.NewReader(serialized)).Parse()
+		if err != nil {
+			t.Errorf("%d. %q: unexpected error reparsing %q: %s", i, s, serialized, err)
+		} else if !reflect.DeepEqual(stmts, reparsed) {
+			t.Errorf("%d. %q\n\nround trip mismatch:\n\nexp=%#v\n\ngot=%#v\n\nserialized=%s", i, s, stmts, reparsed, serialized)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package polai
 
+import "fmt"
+
 // Token represents a lexical token.
 type Token int
 
@@ -88,4 +90,106 @@ const (
 	ACTION
 	RESOURCE
 	CONTEXT
+	IS
+	LETBIND
+
+	// Later additions, appended to keep the values of the tokens above unchanged
+
+	SLOT       // ?principal
+	LETIDENT   // reference to a let binding
+	DATETIME   // extension
+	DURATION   // extension
+	SLASH      // /
+	PERCENT    // %
+	ATSIGN     // @
+	ASSIGN     // =
+	UNARYMINUS // - (negation)
 )
+
+var tokenNames = map[Token]string{
+	ILLEGAL:               "ILLEGAL",
+	EOF:                   "EOF",
+	WHITESPC:              "WHITESPC",
+	ERROR:                 "ERROR",
+	IDENT:                 "IDENT",
+	LONG:                  "LONG",
+	DBLQUOTESTR:           "DBLQUOTESTR",
+	COMMENT:               "COMMENT",
+	SLOT:                  "SLOT",
+	ENTITY:                "ENTITY",
+	ATTRIBUTE:             "ATTRIBUTE",
+	RECORDKEY:             "RECORDKEY",
+	SET:                   "SET",
+	FUNCTION:              "FUNCTION",
+	RECORD:                "RECORD",
+	LETIDENT:              "LETIDENT",
+	ELSE_TRUE:             "ELSE_TRUE",
+	ELSE_FALSE:            "ELSE_FALSE",
+	THEN_TRUE_ELSE_TRUE:   "THEN_TRUE_ELSE_TRUE",
+	THEN_TRUE_ELSE_FALSE:  "THEN_TRUE_ELSE_FALSE",
+	THEN_FALSE_ELSE_TRUE:  "THEN_FALSE_ELSE_TRUE",
+	THEN_FALSE_ELSE_FALSE: "THEN_FALSE_ELSE_FALSE",
+	THEN_TRUE_ELSE_ERROR:  "THEN_TRUE_ELSE_ERROR",
+	THEN_FALSE_ELSE_ERROR: "THEN_FALSE_ELSE_ERROR",
+	THEN_ERROR_ELSE_TRUE:  "THEN_ERROR_ELSE_TRUE",
+	THEN_ERROR_ELSE_FALSE: "THEN_ERROR_ELSE_FALSE",
+	IP:                    "IP",
+	DECIMAL:               "DECIMAL",
+	DATETIME:              "DATETIME",
+	DURATION:              "DURATION",
+	LEFT_PAREN:            "LEFT_PAREN",
+	RIGHT_PAREN:           "RIGHT_PAREN",
+	LEFT_SQB:              "LEFT_SQB",
+	RIGHT_SQB:             "RIGHT_SQB",
+	LEFT_BRACE:            "LEFT_BRACE",
+	RIGHT_BRACE:           "RIGHT_BRACE",
+	PERIOD:                "PERIOD",
+	COMMA:                 "COMMA",
+	SEMICOLON:             "SEMICOLON",
+	EXCLAMATION:           "EXCLAMATION",
+	LT:                    "LT",
+	GT:                    "GT",
+	DASH:                  "DASH",
+	PLUS:                  "PLUS",
+	MULTIPLIER:            "MULTIPLIER",
+	SLASH:                 "SLASH",
+	PERCENT:               "PERCENT",
+	COLON:                 "COLON",
+	ATSIGN:                "ATSIGN",
+	ASSIGN:                "ASSIGN",
+	NAMESPACE:             "NAMESPACE",
+	EQUALITY:              "EQUALITY",
+	INEQUALITY:            "INEQUALITY",
+	LTE:                   "LTE",
+	GTE:                   "GTE",
+	AND:                   "AND",
+	OR:                    "OR",
+	UNARYMINUS:            "UNARYMINUS",
+	PERMIT:                "PERMIT",
+	FORBID:                "FORBID",
+	WHEN:                  "WHEN",
+	UNLESS:                "UNLESS",
+	TRUE:                  "TRUE",
+	FALSE:                 "FALSE",
+	IF:                    "IF",
+	THEN:                  "THEN",
+	ELSE:                  "ELSE",
+	IN:                    "IN",
+	IS:                    "IS",
+	LIKE:                  "LIKE",
+	HAS:                   "HAS",
+	PRINCIPAL:             "PRINCIPAL",
+	ACTION:                "ACTION",
+	RESOURCE:              "RESOURCE",
+	CONTEXT:               "CONTEXT",
+	LETBIND:               "LETBIND",
+}
+
+// String returns the name of the token, e.g. "PERMIT".
+func (tok Token) String() string {
+	if name, ok := tokenNames[tok]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Token(%d)", int(tok))
+}
@@ -0,0 +1,49 @@
+package polai_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/iann0036/polai"
+)
+
+// Ensure every token has a human-readable name.
+func TestToken_String(t *testing.T) {
+	seen := map[string]polai.Token{}
+	for tok := polai.ILLEGAL; tok <= polai.UNARYMINUS; tok++ {
+		name := tok.String()
+		if name == "" || strings.HasPrefix(name, "Token(") {
+			t.Errorf("missing name for token %d", int(tok))
+		}
+		if other, ok := seen[name]; ok {
+			t.Errorf("duplicate name %q for tokens %d and %d", name, int(other), int(tok))
+		}
+		seen[name] = tok
+	}
+
+	if name := polai.PERMIT.String(); name != "PERMIT" {
+		t.Errorf("name mismatch: exp=PERMIT got=%s", name)
+	}
+	if name := (polai.UNARYMINUS + 1).String(); !strings.HasPrefix(name, "Token(") {
+		t.Errorf("unexpected name for unknown token: %s", name)
+	}
+}
+
+// Ensure tokens keep their values as new tokens are added.
+func TestToken_Values(t *testing.T) {
+	for _, tt := range []struct {
+		tok polai.Token
+		exp int
+	}{
+		{tok: polai.ILLEGAL, exp: 0},
+		{tok: polai.ENTITY, exp: 8},
+		{tok: polai.IP, exp: 24},
+		{tok: polai.COLON, exp: 41},
+		{tok: polai.OR, exp: 48},
+		{tok: polai.CONTEXT, exp: 64},
+	} {
+		if int(tt.tok) != tt.exp {
+			t.Errorf("value mismatch for %s: exp=%d got=%d", tt.tok, tt.exp, int(tt.tok))
+		}
+	}
+}
@@ -1,5 +1,12 @@
 package polai
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
 func contains(s []string, str string) bool {
 	for _, v := range s {
 		if v == str {
@@ -19,3 +26,181 @@ func containsEntity(list []Entity, id string) bool {
 
 	return false
 }
+
+// entityType returns the type portion of an entity identifier, which is everything before the final namespace
+// separator (e.g. Org::User for Org::User::"alice"). An empty string is returned for non-entity values.
+func entityType(identifier string) string {
+	i := strings.Index(identifier, "::\"")
+	if i < 0 {
+		return ""
+	}
+
+	return identifier[:i]
+}
+
+// isEntityIdentifier returns whether the identifier is a namespaced type followed by a quoted id, such as
+// Org::User::"alice".
+func isEntityIdentifier(identifier string) bool {
+	typ := entityType(identifier)
+	if typ == "" {
+		return false
+	}
+	for _, part := range strings.Split(typ, "::") {
+		if !isIdentifier(part) {
+			return false
+		}
+	}
+
+	_, err := unquoteString(identifier[len(typ)+2:])
+	return err == nil
+}
+
+// isIdentifier returns whether the string is a valid namespace segment, starting with a letter or underscore and
+// containing only letters, digits and underscores.
+func isIdentifier(s string) bool {
+	if s == "" || unicode.IsDigit([]rune(s)[0]) {
+		return false
+	}
+	for _, ch := range s {
+		if !isLetter(ch) && !unicode.IsDigit(ch) && ch != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// unquoteString decodes a double-quoted string literal, resolving \n, \t, \r, \0, \\, \", \', \/, \* and
+// \uXXXX (or \u{X...}) escapes. Like patterns are built from the literal instead, see likePattern.
+func unquoteString(lit string) (string, error) {
+	if len(lit) < 2 || lit[0] != '"' || lit[len(lit)-1] != '"' {
+		return "", fmt.Errorf("invalid string literal %s", lit)
+	}
+
+	var b strings.Builder
+	runes := []rune(lit[1 : len(lit)-1])
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '\\' {
+			b.WriteRune(runes[i])
+			continue
+		}
+
+		r, end, err := unquoteEscape(lit, runes, i+1)
+		if err != nil {
+			return "", err
+		}
+		b.WriteRune(r)
+		i = end
+	}
+
+	return b.String(), nil
+}
+
+// unquoteEscape decodes the escape sequence following the backslash at runes[i-1] of the string literal lit,
+// returning the decoded rune and the index of the last rune of the sequence.
+func unquoteEscape(lit string, runes []rune, i int) (rune, int, error) {
+	if i >= len(runes) {
+		return 0, i, fmt.Errorf("invalid escape sequence at end of string %s", lit)
+	}
+
+	switch runes[i] {
+	case 'n':
+		return '\n', i, nil
+	case 't':
+		return '\t', i, nil
+	case 'r':
+		return '\r', i, nil
+	case '0':
+		return 0, i, nil
+	case '\\', '"', '\'', '/', '*':
+		return runes[i], i, nil
+	case 'u':
+		var hex string
+		if i+1 < len(runes) && runes[i+1] == '{' {
+			end := i + 2
+			for end < len(runes) && runes[end] != '}' {
+				end++
+			}
+			if end >= len(runes) || end-(i+2) < 1 || end-(i+2) > 6 {
+				return 0, i, fmt.Errorf("invalid unicode escape sequence in string %s", lit)
+			}
+			hex = string(runes[i+2 : end])
+			i = end
+		} else {
+			if i+4 >= len(runes) {
+				return 0, i, fmt.Errorf("invalid unicode escape sequence in string %s", lit)
+			}
+			hex = string(runes[i+1 : i+5])
+			i += 4
+		}
+		cp, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || cp > 0x10FFFF {
+			return 0, i, fmt.Errorf("invalid unicode escape sequence in string %s", lit)
+		}
+		return rune(cp), i, nil
+	}
+
+	return 0, i, fmt.Errorf("invalid escape sequence \\%c in string %s", runes[i], lit)
+}
+
+// likePattern converts the double-quoted literal of a like pattern into a pattern for the match package. An
+// unescaped asterisk is a wildcard and an escaped one, \*, matches a literal asterisk, which can only be told apart
+// in the literal. Any other character, once unescaped, is matched literally.
+func likePattern(lit string) (string, error) {
+	if len(lit) < 2 || lit[0] != '"' || lit[len(lit)-1] != '"' {
+		return "", fmt.Errorf("invalid string literal %s", lit)
+	}
+
+	var b strings.Builder
+	runes := []rune(lit[1 : len(lit)-1])
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == '*' {
+			b.WriteRune('*')
+			continue
+		}
+		if r == '\\' {
+			var err error
+			if r, i, err = unquoteEscape(lit, runes, i+1); err != nil {
+				return "", err
+			}
+		}
+		// the match package treats backslashes and asterisks as special, so they are escaped
+		if r == '\\' || r == '*' {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String(), nil
+}
+
+// quoteString encodes a string as a double-quoted string literal, such that unquoteString returns the original.
+func quoteString(str string) string {
+	var b strings.Builder
+
+	b.WriteRune('"')
+	for _, ch := range str {
+		switch ch {
+		case '"', '\\':
+			b.WriteRune('\\')
+			b.WriteRune(ch)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		case 0:
+			b.WriteString(`\0`)
+		default:
+			if unicode.IsControl(ch) {
+				fmt.Fprintf(&b, `\u{%x}`, ch)
+			} else {
+				b.WriteRune(ch)
+			}
+		}
+	}
+	b.WriteRune('"')
+
+	return b.String()
+}